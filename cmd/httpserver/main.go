@@ -1,55 +1,140 @@
 package main
 
 import (
+	"context"
 	"crypto/sha256"
+	"errors"
+	"flag"
 	"fmt"
-	"httpfromtcp/internal/headers"
 	"httpfromtcp/internal/request"
 	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/router"
 	"httpfromtcp/internal/server"
 	"io"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"strings"
 	"syscall"
+	"time"
 )
 
-const port = 42069
+const defaultListen = ":42069"
+
+// listenFlags collects repeated -listen flags.
+type listenFlags []string
+
+func (f *listenFlags) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *listenFlags) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+// shutdownTimeout is how long in-flight requests get to finish on SIGTERM.
+const shutdownTimeout = 30 * time.Second
 
 func main() {
-	server, err := server.Serve(port, handler)
-	if err != nil {
-		log.Fatalf("Error starting server: %v", err)
+	var listens listenFlags
+	flag.Var(&listens, "listen", "address to listen on: host:port, unix:/path or systemd; repeatable (default "+defaultListen+")")
+	flag.Parse()
+	if len(listens) == 0 {
+		listens = listenFlags{defaultListen}
 	}
+
+	handler := server.Logging(log.Default())(routes().Serve)
+	server := server.New(handler)
 	defer server.Close()
-	log.Println("Server started on port", port)
+	if err := serve(server, listens); err != nil {
+		log.Fatal(err)
+	}
+	for _, addr := range server.Addrs() {
+		log.Printf("Server listening on %s %s", addr.Network(), addr)
+	}
 
 	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-	<-sigChan
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGUSR2)
+	for sig := range sigChan {
+		if sig == syscall.SIGHUP || sig == syscall.SIGUSR2 {
+			process, err := server.Restart()
+			if err != nil {
+				log.Printf("Error restarting, still serving: %v", err)
+				continue
+			}
+			log.Printf("Handed over to process %d", process.Pid)
+		}
+		break
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("Error shutting down server: %v", err)
+	}
 	log.Println("Server gracefully stopped")
 }
 
-func handler(w *response.Writer, req *request.Request) {
-	if req.RequestLine.RequestTarget == "/yourproblem" {
-		handler400(w, req)
-		return
+// serve starts s on the listeners inherited from a restart, or else on the
+// -listen addresses, and tells the old process when it is ready.
+func serve(s *server.Server, listens []string) error {
+	inherited, err := server.InheritedListeners()
+	if err != nil {
+		return fmt.Errorf("Error taking over listeners: %w", err)
 	}
-	if req.RequestLine.RequestTarget == "/myproblem" {
-		handler500(w, req)
-		return
+	for _, l := range inherited {
+		if err := s.ServeListener(l); err != nil {
+			return err
+		}
 	}
-	if strings.HasPrefix(req.RequestLine.RequestTarget, "/httpbin") {
-		handlerHttpbin(w, req)
-		return
+	if len(inherited) == 0 {
+		for _, addr := range listens {
+			if err := listen(s, addr); err != nil {
+				return fmt.Errorf("Error starting server on %s: %w", addr, err)
+			}
+		}
 	}
-	if req.RequestLine.RequestTarget == "/video" {
-		handlerVideo(w, req)
-		return
+	return server.NotifyReady()
+}
+
+// listen adds a listener for a -listen address to s.
+func listen(s *server.Server, addr string) error {
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+		return s.ListenUnix(path)
+	}
+	if addr == "systemd" {
+		listeners, err := server.SystemdListeners()
+		if err != nil {
+			return err
+		}
+		if len(listeners) == 0 {
+			return errors.New("no sockets passed by systemd")
+		}
+		for _, l := range listeners {
+			if err := s.ServeListener(l); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
 	}
-	handler200(w, req)
+	return s.ServeListener(l)
+}
+
+func routes() *router.Router {
+	r := router.New()
+	r.Handle("/yourproblem", handler400)
+	r.Handle("/myproblem", handler500)
+	r.Handle("GET /httpbin/{path...}", handlerHttpbin)
+	r.Handle("GET /video", handlerVideo)
+	r.Handle("/{path...}", handler200)
+	return r
 }
 
 func handler400(w *response.Writer, _ *request.Request) {
@@ -64,10 +149,7 @@ func handler400(w *response.Writer, _ *request.Request) {
 </body>
 </html>
 `)
-	h := response.GetDefaultHeaders(len(body))
-	h.Override("Content-Type", "text/html")
-	w.WriteHeaders(h)
-	w.WriteBody(body)
+	w.Write(body)
 }
 
 func handler500(w *response.Writer, _ *request.Request) {
@@ -82,10 +164,7 @@ func handler500(w *response.Writer, _ *request.Request) {
 </body>
 </html>
 `)
-	h := response.GetDefaultHeaders(len(body))
-	h.Override("Content-Type", "text/html")
-	w.WriteHeaders(h)
-	w.WriteBody(body)
+	w.Write(body)
 }
 
 func handler200(w *response.Writer, _ *request.Request) {
@@ -100,17 +179,14 @@ func handler200(w *response.Writer, _ *request.Request) {
 </body>
 </html>
 `)
-	h := response.GetDefaultHeaders(len(body))
-	h.Override("Content-Type", "text/html")
-	w.WriteHeaders(h)
-	w.WriteBody(body)
+	w.Write(body)
 }
 
 func handlerHttpbin(w *response.Writer, req *request.Request) {
-	contentSha := strings.ToLower("X-Content-Sha256")
-	contentLength := strings.ToLower("X-Content-Length")
-
-	target := strings.TrimPrefix(req.RequestLine.RequestTarget, "/httpbin/")
+	target := req.PathValue("path")
+	if req.URL.RawQuery != "" {
+		target += "?" + req.URL.RawQuery
+	}
 	fmt.Printf("target: %s\n", target)
 	url := fmt.Sprintf("https://httpbin.org/%s", target)
 	fmt.Printf("Proxying to %s\n", url)
@@ -122,53 +198,19 @@ func handlerHttpbin(w *response.Writer, req *request.Request) {
 	}
 	defer resp.Body.Close()
 
-	w.WriteStatusLine(response.StatusOK)
-	h := response.GetDefaultHeaders(0)
-	h.Remove("Content-Length")
-	h.Override("Transfer-Encoding", "chunked")
-	h.Set("Trailer", contentSha)
-	h.Set("Trailer", contentLength)
-	w.WriteHeaders(h)
-	
-	fullResponse := []byte{}
-	length := 0
-	
-	buf := make([]byte, 1024)
-	for {
-		n, err := resp.Body.Read(buf)
-		fmt.Printf("read to buffer %d bytes\n", n)
-
-		if n > 0 {
-			_, err := w.WriteChunkedBody(buf[:n])
-			if err != nil {
-				fmt.Printf("error while writing cnunk: %v\n", err)
-				break
-			}
-			fullResponse = append(fullResponse, buf[:n]...)
-			length += n
-		}
-
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			fmt.Printf("error reading chunk: %v\n", err)
-			break
-		}
-
-	}
-	_, err = w.WriteChunkedBodyDone()
+	err = w.DeclareTrailer("X-Content-Sha256", "X-Content-Length")
 	if err != nil {
-		fmt.Printf("error while finishing writing chunk: %v", err)
+		fmt.Printf("error while declaring trailers: %v\n", err)
 	}
 
-	trailers := headers.NewHeaders()
-	trailers.Set(contentSha, fmt.Sprintf("%x", sha256.Sum256(fullResponse)))
-	trailers.Set(contentLength, fmt.Sprintf("%d", length))
-	err = w.WriteTrailers(trailers)
+	hash := sha256.New()
+	length, err := io.Copy(io.MultiWriter(w, hash), resp.Body)
 	if err != nil {
-		fmt.Printf("error while writing trailers: %v\n", err)
+		fmt.Printf("error while proxying the body: %v\n", err)
 	}
+
+	w.SetTrailer("X-Content-Sha256", fmt.Sprintf("%x", hash.Sum(nil)))
+	w.SetTrailer("X-Content-Length", fmt.Sprintf("%d", length))
 }
 
 func handlerVideo(w *response.Writer, req *request.Request) {
@@ -176,11 +218,12 @@ func handlerVideo(w *response.Writer, req *request.Request) {
 	if err != nil {
 		fmt.Printf("error while openning the file: %v\n", err)
 		handler500(w, req)
+		return
 	}
 	w.WriteStatusLine(response.StatusOK)
 	h := response.GetDefaultHeaders(len(file))
-	h.Override("Content-Type", "video/mp4")
+	h.Set("Content-Type", "video/mp4")
 	w.WriteHeaders(h)
 	w.WriteBody(file)
 
-}
\ No newline at end of file
+}
@@ -34,7 +34,7 @@ func main() {
 		fmt.Println("- Target:", request.RequestLine.RequestTarget)
 		fmt.Println("- Version:", request.RequestLine.HttpVersion)
 		fmt.Println("Headers:")
-		for key, value := range request.Headers {
+		for key, value := range request.Headers.All() {
 			fmt.Printf("- %s: %s\n", key, value)
 		}
 		fmt.Println("Body:")
@@ -54,13 +54,13 @@ func main() {
 // 		currentLineContent := ""
 
 // 		for {
-// 			buff := make([]byte, 8) 
+// 			buff := make([]byte, 8)
 // 			n, err := f.Read(buff)
 // 			if err != nil {
 // 				// first check if the current line contains charachters
 // 				if currentLineContent != "" {
 // 					// and return lines
-// 					lines <- currentLineContent 
+// 					lines <- currentLineContent
 // 				}
 // 				if errors.Is(err, io.EOF) {
 // 					break
@@ -68,7 +68,7 @@ func main() {
 // 				fmt.Printf("error: %s\n", err.Error())
 // 				return
 // 			}
-	
+
 // 			str := string(buff[:n])
 // 			parts := strings.Split(str, "\n")
 // 			for i := 0; i < len(parts)-1; i++ {
@@ -80,4 +80,4 @@ func main() {
 // 	}()
 
 // 	return lines
-// }
\ No newline at end of file
+// }
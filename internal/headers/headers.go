@@ -3,6 +3,7 @@ package headers
 import (
 	"bytes"
 	"fmt"
+	"iter"
 	"slices"
 	"strings"
 )
@@ -10,64 +11,223 @@ import (
 const crlf = "\r\n"
 const colon = ":"
 
-type Headers map[string]string
+// field is a single field line, with the name as it was received or set.
+type field struct {
+	name  string
+	value string
+}
+
+// Headers is an ordered list of field lines. Lookups are case-insensitive,
+// repeated fields are kept as separate lines, in order.
+type Headers struct {
+	fields []field
+}
 
-func NewHeaders() Headers {
-	return map[string]string{}
+func NewHeaders() *Headers {
+	return &Headers{}
 }
 
-func (h Headers) Parse(data []byte) (n int, done bool, err error) {
-	idx := bytes.Index(data, []byte(crlf))
+// ObsFoldPolicy says what to do with obsolete line folding: a field line
+// that starts with a space or tab and continues the previous one.
+type ObsFoldPolicy int
+
+const (
+	// ObsFoldReject rejects folded field lines.
+	ObsFoldReject ObsFoldPolicy = iota
+	// ObsFoldUnfold replaces each fold with a single space, joining the
+	// continuation to the previous field value.
+	ObsFoldUnfold
+)
+
+// Parse parses one field line, rejecting obs-fold.
+func (h *Headers) Parse(data []byte) (n int, done bool, err error) {
+	return h.ParseFolding(data, ObsFoldReject)
+}
+
+// ParseFolding parses one field line, handling obs-fold with policy.
+func (h *Headers) ParseFolding(data []byte, policy ObsFoldPolicy) (n int, done bool, err error) {
+	idx := bytes.IndexByte(data, '\n')
 	if idx == -1 {
 		return 0, false, nil
 	}
+	if idx == 0 || data[idx-1] != '\r' {
+		return 0, false, fmt.Errorf("bare LF in field line")
+	}
+	idx--
 	if idx == 0 {
 		return 2, true, nil
 	}
 
-	headerName, headerValue, found := bytes.Cut(data[:idx], []byte(":"))
+	line := data[:idx]
+	if line[0] == ' ' || line[0] == '\t' {
+		if len(h.fields) == 0 {
+			// there is nothing to continue, and recipients that drop the
+			// line would see different fields (RFC 9112 section 2.2)
+			return 0, false, fmt.Errorf("whitespace before the first field line: %q", line)
+		}
+		if policy != ObsFoldUnfold {
+			return 0, false, fmt.Errorf("obsolete line folding: %q", line)
+		}
+		continuation := strings.Trim(string(line), " \t")
+		if !ValidFieldValue(continuation) {
+			return 0, false, fmt.Errorf("invalid header value: %q", continuation)
+		}
+		last := &h.fields[len(h.fields)-1]
+		if continuation != "" {
+			last.value = strings.TrimRight(last.value+" "+continuation, " ")
+		}
+		return idx + 2, false, nil
+	}
+
+	headerName, headerValue, found := bytes.Cut(line, []byte(":"))
 	if !found {
-		return idx+2, false, fmt.Errorf("invalid header: %s", data[:idx])
+		return idx + 2, false, fmt.Errorf("invalid header: %s", line)
 	}
 
 	key := string(headerName)
-	if key != strings.TrimRight(key, " ") {
+	// no whitespace is allowed between the name and the colon
+	if key != strings.TrimRight(key, " \t") {
 		return 0, false, fmt.Errorf("invalid header name: %s", key)
 	}
 
-	key = strings.TrimSpace(key)
-	value := strings.TrimSpace(string(headerValue))
+	// OWS around the value is only spaces and tabs
+	value := strings.Trim(string(headerValue), " \t")
 
-	if !validTokens([]byte(key)) {
+	if !ValidFieldName(key) {
 		return 0, false, fmt.Errorf("invalid characters in header: %s", key)
 	}
+	if !ValidFieldValue(value) {
+		return 0, false, fmt.Errorf("invalid header value for %s: %q", key, value)
+	}
 
-	h.Set(key, value)
+	h.Add(key, value)
 	return idx + 2, false, nil
 }
 
-func (h Headers) Set(key, value string) {
-	key = strings.ToLower(key)
-	v, ok := h[key]
-	if ok {
-		value = strings.Join([]string{v, value}, ", ")
+// Add appends a field line, keeping any existing ones with the same name.
+func (h *Headers) Add(key, value string) {
+	h.fields = append(h.fields, field{name: key, value: value})
+}
+
+// Set replaces all field lines named key with a single one. The new line
+// takes the place of the first old one.
+func (h *Headers) Set(key, value string) {
+	replaced := false
+	fields := h.fields[:0]
+	for _, f := range h.fields {
+		if !strings.EqualFold(f.name, key) {
+			fields = append(fields, f)
+			continue
+		}
+		if !replaced {
+			fields = append(fields, field{name: key, value: value})
+			replaced = true
+		}
+	}
+	h.fields = fields
+	if !replaced {
+		h.Add(key, value)
 	}
-	h[key] = value
 }
 
-func (h Headers) Get(key string) (string, bool) {
-	v, ok := h[strings.ToLower(key)]
-	return v, ok
+// Del removes all field lines named key.
+func (h *Headers) Del(key string) {
+	h.fields = slices.DeleteFunc(h.fields, func(f field) bool {
+		return strings.EqualFold(f.name, key)
+	})
 }
 
-func (h Headers) Override(key, value string) {
-	key = strings.ToLower(key)
-	h[key] = value
+// Get returns the values of all field lines named key, joined with ", ".
+// Joining is wrong for Set-Cookie, use Values for that.
+func (h *Headers) Get(key string) (string, bool) {
+	values := h.Values(key)
+	if len(values) == 0 {
+		return "", false
+	}
+	return strings.Join(values, ", "), true
 }
 
-func (h Headers) Remove(key string) {
-	key = strings.ToLower(key)
-	delete(h, key)
+// Values returns the values of all field lines named key, in order.
+func (h *Headers) Values(key string) []string {
+	var values []string
+	for _, f := range h.fields {
+		if strings.EqualFold(f.name, key) {
+			values = append(values, f.value)
+		}
+	}
+	return values
+}
+
+// Len returns the number of field lines.
+func (h *Headers) Len() int {
+	return len(h.fields)
+}
+
+// All iterates over the field lines in order, yielding each name as it was
+// received or set.
+func (h *Headers) All() iter.Seq2[string, string] {
+	return func(yield func(string, string) bool) {
+		for _, f := range h.fields {
+			if !yield(f.name, f.value) {
+				return
+			}
+		}
+	}
+}
+
+// ContainsToken reports whether the comma-separated lists in the header
+// contain token, compared case-insensitively.
+func (h *Headers) ContainsToken(key, token string) bool {
+	for _, v := range h.Values(key) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// CanonicalKey returns the canonical case of a field name, e.g.
+// "content-type" becomes "Content-Type". Names that are not valid tokens
+// are returned unchanged.
+func CanonicalKey(key string) string {
+	if !validTokens([]byte(key)) {
+		return key
+	}
+	b := []byte(key)
+	upper := true
+	for i, c := range b {
+		if upper && c >= 'a' && c <= 'z' {
+			b[i] = c - 'a' + 'A'
+		} else if !upper && c >= 'A' && c <= 'Z' {
+			b[i] = c - 'A' + 'a'
+		}
+		upper = c == '-'
+	}
+	return string(b)
+}
+
+// ValidFieldName reports whether name is a non-empty token.
+func ValidFieldName(name string) bool {
+	return name != "" && validTokens([]byte(name))
+}
+
+// ValidFieldValue reports whether value is a valid field-value per
+// RFC 9110: visible characters, obs-text, and spaces or tabs between them.
+// In particular it rejects CR, LF and NUL, which would allow injecting
+// field lines.
+func ValidFieldValue(value string) bool {
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if c == ' ' || c == '\t' {
+			continue
+		}
+		if c < 0x21 || c == 0x7f {
+			return false
+		}
+	}
+	return true
 }
 
 var tokenChars = []byte{'!', '#', '$', '%', '&', '\'', '*', '+', '-', '.', '^', '_', '`', '|', '~'}
@@ -91,4 +251,4 @@ func isTokenChar(c byte) bool {
 	}
 
 	return slices.Contains(tokenChars, c)
-}
\ No newline at end of file
+}
@@ -14,7 +14,7 @@ func TestFieldLineParse(t *testing.T) {
 	n, done, err := headers.Parse(data)
 	require.NoError(t, err)
 	require.NotNil(t, headers)
-	assert.Equal(t, "localhost:42069", headers["host"])
+	assert.Equal(t, []string{"localhost:42069"}, headers.Values("host"))
 	assert.Equal(t, 23, n)
 	assert.False(t, done)
 
@@ -26,24 +26,24 @@ func TestFieldLineParse(t *testing.T) {
 	assert.Equal(t, 0, n)
 	assert.False(t, done)
 
-	// Test: Valid single header with extra whitespace
+	// Test: Whitespace before the first field line
 	headers = NewHeaders()
 	data = []byte("       Host: localhost:42069                           \r\n\r\n")
 	n, done, err = headers.Parse(data)
-	require.NoError(t, err)
-	require.NotNil(t, headers)
-	assert.Equal(t, "localhost:42069", headers["host"])
-	assert.Equal(t, 57, n)
+	require.Error(t, err)
+	assert.Equal(t, 0, n)
 	assert.False(t, done)
+	assert.Equal(t, 0, headers.Len())
 
 	// Test: Valid 2 headers with existing headers
-	headers = map[string]string{"host": "localhost:42069"}
+	headers = NewHeaders()
+	headers.Add("host", "localhost:42069")
 	data = []byte("User-Agent: curl/7.81.0\r\nAccept: */*\r\n\r\n")
 	n, done, err = headers.Parse(data)
 	require.NoError(t, err)
 	require.NotNil(t, headers)
-	assert.Equal(t, "localhost:42069", headers["host"])
-	assert.Equal(t, "curl/7.81.0", headers["user-agent"])
+	assert.Equal(t, []string{"localhost:42069"}, headers.Values("host"))
+	assert.Equal(t, []string{"curl/7.81.0"}, headers.Values("user-agent"))
 	assert.Equal(t, 25, n)
 	assert.False(t, done)
 
@@ -53,7 +53,7 @@ func TestFieldLineParse(t *testing.T) {
 	n, done, err = headers.Parse(data)
 	require.NoError(t, err)
 	require.NotNil(t, headers)
-	assert.Empty(t, headers)
+	assert.Equal(t, 0, headers.Len())
 	assert.Equal(t, 2, n)
 	assert.True(t, done)
 
@@ -66,12 +66,135 @@ func TestFieldLineParse(t *testing.T) {
 	assert.False(t, done)
 
 	// Test: Valid 2 headers with existing headers
-	headers = map[string]string{"set-person": "lane-loves-go"}
+	headers = NewHeaders()
+	headers.Add("set-person", "lane-loves-go")
 	data = []byte("Set-Person: prime-loves-zig\r\nAccept: */*\r\n\r\n")
 	n, done, err = headers.Parse(data)
 	require.NoError(t, err)
 	require.NotNil(t, headers)
-	assert.Equal(t, "lane-loves-go, prime-loves-zig", headers["set-person"])
+	assert.Equal(t, []string{"lane-loves-go", "prime-loves-zig"}, headers.Values("set-person"))
+	v, ok := headers.Get("Set-Person")
+	assert.True(t, ok)
+	assert.Equal(t, "lane-loves-go, prime-loves-zig", v)
 	assert.Equal(t, 29, n)
 	assert.False(t, done)
-}
\ No newline at end of file
+}
+func TestHeadersFieldLines(t *testing.T) {
+	h := NewHeaders()
+	h.Add("Content-Type", "text/html")
+	h.Add("Set-Cookie", "a=1; Path=/")
+	h.Add("x-request-id", "42")
+	h.Add("set-cookie", "b=2, c=3")
+
+	// Test: Repeated fields stay separate lines
+	assert.Equal(t, []string{"a=1; Path=/", "b=2, c=3"}, h.Values("SET-COOKIE"))
+	assert.Equal(t, 4, h.Len())
+	v, ok := h.Get("Set-Cookie")
+	assert.True(t, ok)
+	assert.Equal(t, "a=1; Path=/, b=2, c=3", v)
+	_, ok = h.Get("Missing")
+	assert.False(t, ok)
+	assert.Nil(t, h.Values("Missing"))
+
+	// Test: Order and original names are kept
+	names := []string{}
+	for name := range h.All() {
+		names = append(names, name)
+	}
+	assert.Equal(t, []string{"Content-Type", "Set-Cookie", "x-request-id", "set-cookie"}, names)
+
+	// Test: Set replaces all lines in place of the first one
+	h.Set("SET-COOKIE", "d=4")
+	assert.Equal(t, []string{"d=4"}, h.Values("set-cookie"))
+	names = []string{}
+	for name := range h.All() {
+		names = append(names, name)
+	}
+	assert.Equal(t, []string{"Content-Type", "SET-COOKIE", "x-request-id"}, names)
+
+	// Test: Set appends a new field
+	h.Set("Cache-Control", "no-store")
+	assert.Equal(t, []string{"no-store"}, h.Values("cache-control"))
+	assert.Equal(t, 4, h.Len())
+
+	// Test: Del removes all lines
+	h.Add("x-request-id", "43")
+	h.Del("X-Request-Id")
+	assert.Nil(t, h.Values("x-request-id"))
+	assert.Equal(t, 3, h.Len())
+
+	// Test: Tokens across repeated lines
+	h.Add("Connection", "keep-alive")
+	h.Add("Connection", "Upgrade, close")
+	assert.True(t, h.ContainsToken("connection", "CLOSE"))
+	assert.False(t, h.ContainsToken("connection", "clo"))
+}
+
+func TestCanonicalKey(t *testing.T) {
+	assert.Equal(t, "Content-Type", CanonicalKey("content-type"))
+	assert.Equal(t, "Content-Type", CanonicalKey("CONTENT-TYPE"))
+	assert.Equal(t, "X-Content-Sha256", CanonicalKey("x-content-sha256"))
+	assert.Equal(t, "Www-Authenticate", CanonicalKey("WWW-Authenticate"))
+	assert.Equal(t, "Host", CanonicalKey("host"))
+	assert.Equal(t, "bad key", CanonicalKey("bad key"))
+}
+
+func TestFieldValueParse(t *testing.T) {
+	// Test: Tabs, inner spaces and obs-text are allowed
+	h := NewHeaders()
+	n, done, err := h.Parse([]byte("X-Note:\tcaf\xe9 au lait \t\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, 24, n)
+	assert.False(t, done)
+	assert.Equal(t, []string{"caf\xe9 au lait"}, h.Values("x-note"))
+
+	// Test: Control characters in the value
+	for _, data := range []string{
+		"X-Note: a\x00b\r\n",
+		"X-Note: a\rb\r\n",
+		"X-Note: a\nInjected: 1\r\n",
+		"X-Note: a\x1bb\r\n",
+		"X-Note: a\x7fb\r\n",
+	} {
+		h = NewHeaders()
+		n, _, err = h.Parse([]byte(data))
+		require.Error(t, err, "%q", data)
+		assert.Equal(t, 0, n)
+		assert.Equal(t, 0, h.Len())
+	}
+
+	// Test: Empty name
+	h = NewHeaders()
+	_, _, err = h.Parse([]byte(": value\r\n"))
+	require.Error(t, err)
+
+	// Test: Obs-fold is rejected by default
+	h = NewHeaders()
+	data := []byte("X-Note: one\r\n  two\r\n\r\n")
+	n, _, err = h.Parse(data)
+	require.NoError(t, err)
+	_, _, err = h.Parse(data[n:])
+	require.Error(t, err)
+
+	// Test: Obs-fold is unfolded into a single space
+	h = NewHeaders()
+	data = []byte("X-Note: one\r\n  two\r\n\tthree \r\n\r\n")
+	read := 0
+	for {
+		n, done, err = h.ParseFolding(data[read:], ObsFoldUnfold)
+		require.NoError(t, err)
+		read += n
+		if done {
+			break
+		}
+	}
+	assert.Equal(t, len(data), read)
+	assert.Equal(t, []string{"one two three"}, h.Values("x-note"))
+	assert.Equal(t, 1, h.Len())
+
+	// Test: A first line can't be a continuation, even when unfolding
+	h = NewHeaders()
+	_, _, err = h.ParseFolding([]byte(" Host: a\r\n\r\n"), ObsFoldUnfold)
+	require.Error(t, err)
+	assert.Equal(t, 0, h.Len())
+}
@@ -0,0 +1,97 @@
+package request
+
+import (
+	"errors"
+	"io"
+	"math"
+)
+
+// maxDiscardBytes is how much of an unread body Close is willing to read
+// and throw away so that the connection can be reused.
+const maxDiscardBytes = 256 << 10
+
+var ErrBodyNotConsumed = errors.New("request body was not fully read")
+var errBodyClosed = errors.New("read on closed request body")
+
+// bodyReader pulls the body of a request read with ReadRequestHeaders off
+// the connection as the handler asks for it.
+type bodyReader struct {
+	request *Request
+	reader  *Reader
+	closed  bool
+	// err is sticky, once the framing broke the connection is unusable
+	err error
+}
+
+func (b *bodyReader) Read(p []byte) (int, error) {
+	if b.closed {
+		return 0, errBodyClosed
+	}
+	if b.err != nil {
+		return 0, b.err
+	}
+
+	request := b.request
+	if len(request.body) == 0 && request.state != requestStateDone {
+		err := b.reader.advance(request, func() bool { return len(request.body) > 0 })
+		if err != nil {
+			b.err = err
+			return 0, err
+		}
+	}
+	if len(request.body) == 0 {
+		return 0, io.EOF
+	}
+
+	n := copy(p, request.body)
+	request.body = request.body[n:]
+	return n, nil
+}
+
+// Close discards what is left of the body so the connection can carry the
+// next request. It gives up after maxDiscardBytes and returns
+// ErrBodyNotConsumed, in which case the connection has to be closed.
+func (b *bodyReader) Close() error {
+	if b.closed {
+		return b.err
+	}
+	b.closed = true
+	return b.discard(maxDiscardBytes)
+}
+
+func (b *bodyReader) discard(limit int) error {
+	if b.err != nil {
+		return b.err
+	}
+
+	request := b.request
+	discarded := 0
+	for {
+		discarded += len(request.body)
+		request.body = request.body[:0]
+		if request.state == requestStateDone {
+			return nil
+		}
+		if discarded > limit {
+			b.err = ErrBodyNotConsumed
+			return b.err
+		}
+		err := b.reader.advance(request, func() bool { return len(request.body) > 0 })
+		if err != nil {
+			b.err = err
+			return err
+		}
+	}
+}
+
+// finishBody skips over whatever the previous request left of its body.
+func (rr *Reader) finishBody() error {
+	if rr.body == nil {
+		return nil
+	}
+	if err := rr.body.discard(math.MaxInt); err != nil {
+		return err
+	}
+	rr.body = nil
+	return nil
+}
@@ -0,0 +1,37 @@
+package request
+
+import "fmt"
+
+// Status codes a ParseError can carry. They are plain ints so that the
+// parser does not depend on the response package.
+const (
+	StatusBadRequest                  = 400
+	StatusRequestEntityTooLarge       = 413
+	StatusURITooLong                  = 414
+	StatusRequestHeaderFieldsTooLarge = 431
+	StatusNotImplemented              = 501
+	StatusHTTPVersionNotSupported     = 505
+)
+
+// ParseError is returned when the client sent a request the server can't
+// accept. StatusCode is the status the server should answer with before
+// closing the connection.
+type ParseError struct {
+	StatusCode int
+	Err        error
+}
+
+func (e *ParseError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+func newParseError(statusCode int, format string, a ...any) *ParseError {
+	return &ParseError{
+		StatusCode: statusCode,
+		Err:        fmt.Errorf(format, a...),
+	}
+}
@@ -0,0 +1,120 @@
+package request
+
+import (
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// Host returns the host the request is for, lowercased and without a
+// port: the authority of an absolute-form target, else the Host header.
+func (r *Request) Host() string {
+	host := ""
+	if r.URL != nil && r.URL.Host != "" {
+		host = r.URL.Host
+	} else if h, ok := r.Headers.Get("Host"); ok {
+		host = h
+	}
+	return strings.ToLower(stripPort(host))
+}
+
+// validateHost checks there is exactly one valid Host header. HTTP/1.0
+// clients may leave it out.
+func (r *Request) validateHost() error {
+	if len(r.Headers.Values("Host")) > 1 {
+		return fmt.Errorf("duplicate Host header")
+	}
+	host, ok := r.Headers.Get("Host")
+	if !ok {
+		if r.RequestLine.HttpVersion == "1.0" {
+			return nil
+		}
+		return fmt.Errorf("missing Host header")
+	}
+	if !validHost(host) {
+		return fmt.Errorf("invalid Host header: %q", host)
+	}
+	return nil
+}
+
+// validHost checks Host = uri-host [ ":" port ]. An empty value is allowed
+// for targets without an authority.
+func validHost(host string) bool {
+	if host == "" {
+		return true
+	}
+
+	hostname := host
+	port := ""
+	if strings.HasPrefix(host, "[") {
+		// IP-literal
+		end := strings.Index(host, "]")
+		if end == -1 {
+			return false
+		}
+		hostname = host[:end+1]
+		rest := host[end+1:]
+		if rest != "" {
+			if !strings.HasPrefix(rest, ":") {
+				return false
+			}
+			port = rest[1:]
+		}
+		// only IPv6 addresses, without a zone
+		addr, err := netip.ParseAddr(hostname[1:end])
+		if err != nil || !addr.Is6() || addr.Zone() != "" {
+			return false
+		}
+	} else {
+		if idx := strings.LastIndex(host, ":"); idx != -1 {
+			hostname = host[:idx]
+			port = host[idx+1:]
+			if port == "" {
+				return false
+			}
+		}
+		if hostname == "" {
+			return false
+		}
+		// reg-name = *( unreserved / pct-encoded / sub-delims )
+		for i := 0; i < len(hostname); i++ {
+			c := hostname[i]
+			if c == '%' {
+				if i+2 >= len(hostname) || !isHex(hostname[i+1]) || !isHex(hostname[i+2]) {
+					return false
+				}
+				i += 2
+				continue
+			}
+			isAlnum := c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+			if !isAlnum && strings.IndexByte("-._~!$&'()*+,;=", c) == -1 {
+				return false
+			}
+		}
+	}
+
+	if port == "" {
+		return true
+	}
+	for _, c := range []byte(port) {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	n, err := strconv.Atoi(port)
+	return err == nil && n <= 65535
+}
+
+func stripPort(host string) string {
+	if strings.HasPrefix(host, "[") {
+		if end := strings.Index(host, "]"); end != -1 {
+			return host[:end+1]
+		}
+		return host
+	}
+	if idx := strings.LastIndex(host, ":"); idx != -1 {
+		return host[:idx]
+	}
+	return host
+}
@@ -0,0 +1,56 @@
+package request
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHostValidation(t *testing.T) {
+	// Test: Valid hosts
+	for _, host := range []string{"localhost", "localhost:42069", "Example.COM", "a-b.example.com.", "127.0.0.1:80", "[::1]", "[::1]:8080", "[2001:db8::1]", "xn--nxasmq6b.com", "caf%C3%A9.example", "example.com:65535", ""} {
+		_, err := RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: " + host + "\r\n\r\n"))
+		assert.NoError(t, err, host)
+	}
+
+	// Test: Invalid hosts
+	for _, host := range []string{"local host", "example.com:", "example.com:http", ":80", "example.com:123456", "[::1", "[::1]x", "[zz::1]", "[]", "[::1%eth0]", "[127.0.0.1]", "user@example.com", "example.com/path", "a%", "a%4", "a%zz.com", "example.com:65536", "example.com:99999", "[::1]:65536"} {
+		_, err := RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: " + host + "\r\n\r\n"))
+		var parseErr *ParseError
+		require.ErrorAs(t, err, &parseErr, host)
+		assert.Equal(t, StatusBadRequest, parseErr.StatusCode, host)
+	}
+
+	// Test: Missing Host
+	_, err := RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nAccept: */*\r\n\r\n"))
+	require.Error(t, err)
+
+	// Test: Duplicate Host, in any case
+	_, err = RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: a.com\r\nhost: a.com\r\n\r\n"))
+	require.Error(t, err)
+
+	// Test: HTTP/1.0 may leave Host out, but not send a bad one
+	_, err = RequestFromReader(strings.NewReader("GET / HTTP/1.0\r\n\r\n"))
+	require.NoError(t, err)
+	_, err = RequestFromReader(strings.NewReader("GET / HTTP/1.0\r\nHost: a b\r\n\r\n"))
+	require.Error(t, err)
+}
+
+func TestRequestHost(t *testing.T) {
+	// Test: Host header without port
+	r, err := RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: API.Example.com:8080\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "api.example.com", r.Host())
+
+	// Test: Absolute-form target wins over the Host header
+	r, err = RequestFromReader(strings.NewReader("GET http://other.example.com/ HTTP/1.1\r\nHost: api.example.com\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "other.example.com", r.Host())
+
+	// Test: IPv6 literal
+	r, err = RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: [::1]:8080\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "[::1]", r.Host())
+}
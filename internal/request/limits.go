@@ -0,0 +1,52 @@
+package request
+
+// Limits bounds how much a client can make the parser buffer. For every
+// field zero means the default and a negative value means no limit.
+type Limits struct {
+	// MaxRequestLineBytes caps the request line, answered with 414.
+	MaxRequestLineBytes int
+	// MaxHeaderBytes caps the header section (and trailer section) taken
+	// together, answered with 431.
+	MaxHeaderBytes int
+	// MaxHeaderCount caps the number of header and trailer field lines,
+	// answered with 431.
+	MaxHeaderCount int
+	// MaxBodyBytes caps the decoded body, answered with 413. There is no
+	// limit by default, since bodies can be streamed.
+	MaxBodyBytes int
+}
+
+const (
+	defaultMaxRequestLineBytes = 8 << 10
+	defaultMaxHeaderBytes      = 1 << 20
+	defaultMaxHeaderCount      = 100
+	// maxChunkSizeLineBytes caps a chunk-size line with its extensions
+	maxChunkSizeLineBytes = 4 << 10
+)
+
+func limit(value, defaultValue int) int {
+	if value == 0 {
+		return defaultValue
+	}
+	return value
+}
+
+func exceeds(n, max int) bool {
+	return max >= 0 && n > max
+}
+
+func (l Limits) maxRequestLineBytes() int {
+	return limit(l.MaxRequestLineBytes, defaultMaxRequestLineBytes)
+}
+
+func (l Limits) maxHeaderBytes() int {
+	return limit(l.MaxHeaderBytes, defaultMaxHeaderBytes)
+}
+
+func (l Limits) maxHeaderCount() int {
+	return limit(l.MaxHeaderCount, defaultMaxHeaderCount)
+}
+
+func (l Limits) maxBodyBytes() int {
+	return limit(l.MaxBodyBytes, -1)
+}
@@ -2,6 +2,8 @@ package request
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"httpfromtcp/internal/headers"
@@ -10,16 +12,37 @@ import (
 	"strings"
 )
 
-
 type Request struct {
 	RequestLine RequestLine
-	Headers headers.Headers
+	// URL is the parsed RequestLine.RequestTarget.
+	URL     *URL
+	Headers *headers.Headers
+	// Body holds the whole body when the request was read with ReadRequest.
+	// It is nil for requests read with ReadRequestHeaders.
 	Body []byte
-	state requestState
+	// BodyReader streams the body. It is always set, for buffered requests
+	// it reads from Body.
+	BodyReader io.ReadCloser
+	// Trailers holds the trailer fields sent after a chunked body.
+	Trailers *headers.Headers
+	// TLS is the state of the TLS connection the request came in on, or
+	// nil for plain HTTP.
+	TLS        *tls.ConnectionState
+	ctx        context.Context
+	pathValues map[string]string
+	state      requestState
+	limits     Limits
+	obsFold    headers.ObsFoldPolicy
+	// body holds decoded body bytes that were not handed out yet
+	body           []byte
 	bodyLengthRead int
+	contentLength  int
+	chunkRemaining int
+	// fieldBytes and fieldCount add up header and trailer lines
+	fieldBytes int
+	fieldCount int
 }
 
-
 type RequestLine struct {
 	HttpVersion   string
 	RequestTarget string
@@ -30,58 +53,213 @@ type requestState int
 
 const (
 	requestStateInitialied requestState = iota
-	requestStateParsingHeaders 
+	requestStateParsingHeaders
 	requestStateParsingBody
+	requestStateParsingChunkSize
+	requestStateParsingChunkData
+	requestStateParsingChunkDataEnd
+	requestStateParsingTrailers
 	requestStateDone
 )
 
 const crlf = "\r\n"
-const bufferSize = 8
+const bufferSize = 4096
+
+// Reader reads consecutive requests off a single connection. Bytes read
+// past the end of one request are kept around for the next one.
+type Reader struct {
+	Limits
+	// ObsFold says what to do with header lines continued by obs-fold.
+	// The default rejects them.
+	ObsFold     headers.ObsFoldPolicy
+	reader      io.Reader
+	buff        []byte
+	readToIndex int
+	// body is the body of the last request read with ReadRequestHeaders
+	body *bodyReader
+}
+
+func NewReader(reader io.Reader) *Reader {
+	return &Reader{
+		reader: reader,
+		buff:   make([]byte, bufferSize),
+	}
+}
 
 func RequestFromReader(reader io.Reader) (*Request, error) {
-	buff := make([]byte, bufferSize)
-	readToIndex := 0
+	return NewReader(reader).ReadRequest()
+}
+
+// Ready blocks until at least one byte of the next request is available.
+// It returns io.EOF if the connection was closed in between requests.
+func (rr *Reader) Ready() error {
+	if err := rr.finishBody(); err != nil {
+		return err
+	}
+	for rr.readToIndex == 0 {
+		n, err := rr.fill()
+		if n == 0 && err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadRequest parses the next request on the connection, including its
+// whole body. It returns io.EOF if the connection was closed before any
+// byte of the request arrived.
+func (rr *Reader) ReadRequest() (*Request, error) {
+	request, err := rr.readHeaders()
+	if err != nil {
+		return nil, err
+	}
+	err = rr.advance(request, func() bool { return false })
+	if err != nil {
+		return nil, err
+	}
+	request.Body = request.body
+	request.body = nil
+	request.BodyReader = io.NopCloser(bytes.NewReader(request.Body))
+	return request, nil
+}
+
+// ReadRequestHeaders parses the next request up to the end of its headers
+// and leaves the body on the connection, to be pulled through
+// Request.BodyReader. Whatever is left unread of the body is discarded
+// before the next request is read.
+func (rr *Reader) ReadRequestHeaders() (*Request, error) {
+	request, err := rr.readHeaders()
+	if err != nil {
+		return nil, err
+	}
+	request.Body = nil
+	rr.body = &bodyReader{
+		request: request,
+		reader:  rr,
+	}
+	request.BodyReader = rr.body
+	return request, nil
+}
+
+func (rr *Reader) readHeaders() (*Request, error) {
+	if err := rr.finishBody(); err != nil {
+		return nil, err
+	}
+
 	request := Request{
-		state: requestStateInitialied,
-		Headers: headers.NewHeaders(),
-		Body: make([]byte, 0),
+		state:    requestStateInitialied,
+		Headers:  headers.NewHeaders(),
+		limits:   rr.Limits,
+		obsFold:  rr.ObsFold,
+		body:     make([]byte, 0),
+		Trailers: headers.NewHeaders(),
+	}
+	err := rr.advance(&request, func() bool {
+		return request.state >= requestStateParsingBody
+	})
+	if err != nil {
+		return nil, err
 	}
+	return &request, nil
+}
 
-	for request.state != requestStateDone {
-		if readToIndex >= len(buff) {
-			newBuff := make([]byte, len(buff) * 2)
-			copy(newBuff, buff)
-			buff = newBuff
+// advance parses buffered data, reading more from the connection as
+// needed, until stop reports true or the request is done.
+func (rr *Reader) advance(request *Request, stop func() bool) error {
+	for {
+		numBytesParsed, err := request.parse(rr.buff[:rr.readToIndex], stop)
+		if err != nil {
+			return err
 		}
 
-		numBytesRead, err := reader.Read(buff[readToIndex:])
+		copy(rr.buff, rr.buff[numBytesParsed:rr.readToIndex])
+		rr.readToIndex -= numBytesParsed
+
+		if request.state == requestStateDone || stop() {
+			return nil
+		}
+
+		numBytesRead, err := rr.fill()
+		if numBytesRead > 0 {
+			continue
+		}
 		if err != nil {
 			if errors.Is(err, io.EOF) {
-				if request.state != requestStateDone {
-					return nil, fmt.Errorf("incomplete request, in state: %d, read n bytes on EOF: %d", request.state, numBytesRead)
+				if request.state == requestStateInitialied && rr.readToIndex == 0 {
+					return io.EOF
 				}
-				break
+				return fmt.Errorf("incomplete request, in state: %d, read n bytes on EOF: %d: %w", request.state, numBytesRead, io.ErrUnexpectedEOF)
 			}
-			return nil, err
+			return err
 		}
-		readToIndex += numBytesRead
+	}
+}
 
-		numBytesParsed, err := request.parse(buff[:readToIndex])
-		if err != nil {
-			return nil, err
-		}
+// Buffered returns the bytes read off the connection but not parsed yet,
+// e.g. to hand them over along with the connection.
+func (rr *Reader) Buffered() []byte {
+	return bytes.Clone(rr.buff[:rr.readToIndex])
+}
 
-		copy(buff, buff[numBytesParsed:])
-		readToIndex -= numBytesParsed
+// fill reads more data from the underlying reader into the buffer,
+// growing it if it is full.
+func (rr *Reader) fill() (int, error) {
+	if rr.readToIndex >= len(rr.buff) {
+		newBuff := make([]byte, len(rr.buff)*2)
+		copy(newBuff, rr.buff)
+		rr.buff = newBuff
 	}
 
-	return &request, nil
+	numBytesRead, err := rr.reader.Read(rr.buff[rr.readToIndex:])
+	rr.readToIndex += numBytesRead
+	return numBytesRead, err
+}
+
+// Context returns the request's context. For requests from the server it
+// carries the connection's details and is canceled once the server is done
+// with the connection. It is never nil.
+func (r *Request) Context() context.Context {
+	if r.ctx == nil {
+		return context.Background()
+	}
+	return r.ctx
+}
+
+// SetContext replaces the request's context, e.g. to hand values down to
+// the next handler.
+func (r *Request) SetContext(ctx context.Context) {
+	r.ctx = ctx
+}
+
+// PathValue returns the value of the named wildcard in the route pattern
+// that matched the request, or "" if there is none.
+func (r *Request) PathValue(name string) string {
+	return r.pathValues[name]
+}
+
+// SetPathValue sets the value of a named route wildcard, so that
+// PathValue returns it.
+func (r *Request) SetPathValue(name, value string) {
+	if r.pathValues == nil {
+		r.pathValues = map[string]string{}
+	}
+	r.pathValues[name] = value
+}
+
+// KeepAlive reports whether the client allows the connection to be reused
+// after this request. HTTP/1.1 connections persist unless the client asks
+// to close, HTTP/1.0 ones only if the client asks for keep-alive.
+func (r *Request) KeepAlive() bool {
+	if r.RequestLine.HttpVersion == "1.0" {
+		return r.Headers.ContainsToken("Connection", "keep-alive")
+	}
+	return !r.Headers.ContainsToken("Connection", "close")
 }
 
 func parseRequestLine(message []byte) (*RequestLine, int, error) {
-	idx := bytes.Index(message, []byte(crlf))
-	if idx == -1 {
-		return nil, 0, nil
+	idx, err := indexCRLF(message)
+	if err != nil || idx == -1 {
+		return nil, 0, err
 	}
 
 	requestLineText := string(message[:idx])
@@ -116,27 +294,51 @@ func requestLineFromString(str string) (*RequestLine, error) {
 	if httpPart != "HTTP" {
 		return nil, fmt.Errorf("Unrecognized HTTP-version: %s", httpPart)
 	}
-	if version != "1.1" {
-		return nil, fmt.Errorf("Unrecognized HTTP-version: %s", httpPart)
+	if !validVersion(version) && !laterMajorVersion(version) {
+		return nil, fmt.Errorf("Malformed HTTP-version: %s", version)
+	}
+	if version != "1.1" && version != "1.0" {
+		return nil, newParseError(StatusHTTPVersionNotSupported, "Unsupported HTTP-version: %s", version)
 	}
 
 	requestLine := RequestLine{
-		HttpVersion: version,
-		Method: method,
+		HttpVersion:   version,
+		Method:        method,
 		RequestTarget: requestLineParts[1],
 	}
 	return &requestLine, nil
 }
 
-func (r *Request) parse(data []byte) (int, error) {
+// validVersion checks the HTTP-version syntax: DIGIT "." DIGIT
+func validVersion(version string) bool {
+	return len(version) == 3 &&
+		version[0] >= '0' && version[0] <= '9' &&
+		version[1] == '.' &&
+		version[2] >= '0' && version[2] <= '9'
+}
+
+// laterMajorVersion reports whether version names a later major version
+// the way HTTP/2 and HTTP/3 are written, without a minor version
+func laterMajorVersion(version string) bool {
+	return len(version) == 1 && version[0] >= '2' && version[0] <= '9'
+}
+
+func (r *Request) parse(data []byte, stop func() bool) (int, error) {
 	totalBytesParsed := 0
-	for r.state != requestStateDone {
+	for r.state != requestStateDone && !stop() {
+		prevState := r.state
 		n, err := r.parseSingle(data[totalBytesParsed:])
 		if err != nil {
+			var parseErr *ParseError
+			if !errors.As(err, &parseErr) {
+				// anything the parser chokes on is the client's fault
+				err = &ParseError{StatusCode: StatusBadRequest, Err: err}
+			}
 			return 0, err
 		}
 		totalBytesParsed += n
-		if n == 0 {
+		if n == 0 && r.state == prevState {
+			// need more data
 			break
 		}
 	}
@@ -153,45 +355,303 @@ func (r *Request) parseSingle(data []byte) (int, error) {
 			return 0, err
 		}
 		if n == 0 {
+			if exceeds(len(data), r.limits.maxRequestLineBytes()) {
+				return 0, newParseError(StatusURITooLong, "request line too long")
+			}
 			// just need more data
 			return 0, nil
 		}
+		if exceeds(n-2, r.limits.maxRequestLineBytes()) {
+			return 0, newParseError(StatusURITooLong, "request line too long")
+		}
+		url, err := parseRequestTarget(requestLine.Method, requestLine.RequestTarget)
+		if err != nil {
+			return 0, err
+		}
 		r.RequestLine = *requestLine
+		r.URL = url
 		r.state = requestStateParsingHeaders
 		return n, nil
 	case requestStateParsingHeaders:
-		n, done, err := r.Headers.Parse(data)
+		n, done, err := r.parseFields(r.Headers, data)
 		if err != nil {
 			return 0, err
 		}
 		if done {
-			r.state = requestStateParsingBody
+			if err := r.validateHost(); err != nil {
+				return 0, err
+			}
+			err = r.startBody()
 		}
-		return n, nil
+		return n, err
 	case requestStateParsingBody:
-		contentLenStr, ok := r.Headers.Get("Content-Length")
-		if !ok {
-			// assume that if no content-length header is present, there is no body
+		// anything past Content-Length belongs to the next request
+		remaining := r.contentLength - r.bodyLengthRead
+		if len(data) > remaining {
+			data = data[:remaining]
+		}
+		r.body = append(r.body, data...)
+		r.bodyLengthRead += len(data)
+		if r.bodyLengthRead == r.contentLength {
 			r.state = requestStateDone
-			return len(data), nil
 		}
-		contentLen, err := strconv.Atoi(contentLenStr)
+		return len(data), nil
+	case requestStateParsingChunkSize:
+		idx, err := indexCRLF(data)
 		if err != nil {
-			return 0, fmt.Errorf("malformed Content-Length: %s", err)
+			return 0, err
 		}
-		r.Body = append(r.Body, data...)
+		if idx == -1 {
+			if len(data) > maxChunkSizeLineBytes {
+				return 0, fmt.Errorf("chunk size line too long")
+			}
+			return 0, nil
+		}
+		chunkSize, err := parseChunkSize(string(data[:idx]))
+		if err != nil {
+			return 0, err
+		}
+		if exceeds(r.bodyLengthRead+chunkSize, r.limits.maxBodyBytes()) {
+			return 0, newParseError(StatusRequestEntityTooLarge, "body too large")
+		}
+		r.chunkRemaining = chunkSize
+		if chunkSize == 0 {
+			r.state = requestStateParsingTrailers
+		} else {
+			r.state = requestStateParsingChunkData
+		}
+		return idx + 2, nil
+	case requestStateParsingChunkData:
+		if len(data) > r.chunkRemaining {
+			data = data[:r.chunkRemaining]
+		}
+		r.body = append(r.body, data...)
 		r.bodyLengthRead += len(data)
-		if r.bodyLengthRead > contentLen {
-			return 0, fmt.Errorf("Content-Length too large")
+		r.chunkRemaining -= len(data)
+		if r.chunkRemaining == 0 {
+			r.state = requestStateParsingChunkDataEnd
+		}
+		return len(data), nil
+	case requestStateParsingChunkDataEnd:
+		if len(data) < 2 {
+			return 0, nil
+		}
+		if !bytes.HasPrefix(data, []byte(crlf)) {
+			return 0, fmt.Errorf("missing CRLF after chunk data")
+		}
+		r.state = requestStateParsingChunkSize
+		return 2, nil
+	case requestStateParsingTrailers:
+		n, done, err := r.parseFields(r.Trailers, data)
+		if err != nil {
+			return 0, err
 		}
-		if r.bodyLengthRead == contentLen {
+		if done {
 			r.state = requestStateDone
 		}
-		return len(data), nil
+		return n, nil
 	case requestStateDone:
 		return 0, fmt.Errorf("error: trying to read data in a done state")
 	default:
 		return 0, fmt.Errorf("unknown state")
 	}
 
-}
\ No newline at end of file
+}
+
+// parseFields parses one header or trailer line into h, keeping the whole
+// field section within the header limits.
+func (r *Request) parseFields(h *headers.Headers, data []byte) (int, bool, error) {
+	n, done, err := h.ParseFolding(data, r.obsFold)
+	if err != nil {
+		return 0, false, err
+	}
+	if n == 0 {
+		if exceeds(r.fieldBytes+len(data), r.limits.maxHeaderBytes()) {
+			return 0, false, newParseError(StatusRequestHeaderFieldsTooLarge, "header section too large")
+		}
+		return 0, false, nil
+	}
+
+	r.fieldBytes += n
+	if !done {
+		r.fieldCount++
+	}
+	if exceeds(r.fieldBytes, r.limits.maxHeaderBytes()) {
+		return 0, false, newParseError(StatusRequestHeaderFieldsTooLarge, "header section too large")
+	}
+	if exceeds(r.fieldCount, r.limits.maxHeaderCount()) {
+		return 0, false, newParseError(StatusRequestHeaderFieldsTooLarge, "too many header fields")
+	}
+	return n, done, nil
+}
+
+// startBody picks the body framing once the headers are in. Anything that
+// another server in front of us could frame differently is rejected, so
+// that no request can be smuggled past it inside the body of another.
+func (r *Request) startBody() error {
+	transferEncodings := r.Headers.Values("Transfer-Encoding")
+	contentLengths := r.Headers.Values("Content-Length")
+	if len(transferEncodings) > 0 {
+		if len(contentLengths) > 0 {
+			return fmt.Errorf("both Content-Length and Transfer-Encoding are present")
+		}
+		if r.RequestLine.HttpVersion == "1.0" {
+			return fmt.Errorf("Transfer-Encoding in an HTTP/1.0 request")
+		}
+		if err := checkTransferCodings(transferEncodings); err != nil {
+			return err
+		}
+		r.state = requestStateParsingChunkSize
+		return nil
+	}
+	if len(contentLengths) == 0 {
+		// assume that if no content-length header is present, there is no body
+		r.state = requestStateDone
+		return nil
+	}
+	if len(contentLengths) > 1 {
+		return fmt.Errorf("duplicate Content-Length: %s", strings.Join(contentLengths, ", "))
+	}
+	contentLen, err := parseContentLength(contentLengths[0])
+	if err != nil {
+		return err
+	}
+	if exceeds(contentLen, r.limits.maxBodyBytes()) {
+		return newParseError(StatusRequestEntityTooLarge, "body too large")
+	}
+	r.contentLength = contentLen
+	r.state = requestStateParsingBody
+	if contentLen == 0 {
+		r.state = requestStateDone
+	}
+	return nil
+}
+
+// parseContentLength parses Content-Length = 1*DIGIT. Unlike strconv.Atoi
+// it takes no sign, and a list like "5, 5" is rejected rather than merged.
+func parseContentLength(value string) (int, error) {
+	if value == "" {
+		return 0, fmt.Errorf("empty Content-Length")
+	}
+	for _, c := range []byte(value) {
+		if c < '0' || c > '9' {
+			return 0, fmt.Errorf("malformed Content-Length: %q", value)
+		}
+	}
+	contentLen, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("malformed Content-Length: %s", err)
+	}
+	return contentLen, nil
+}
+
+// checkTransferCodings checks the codings listed in the Transfer-Encoding
+// lines. chunked has to come last and only once, or the body length can't
+// be told; any other coding is valid but not supported.
+func checkTransferCodings(values []string) error {
+	var codings []string
+	for _, v := range values {
+		for _, coding := range strings.Split(v, ",") {
+			coding = strings.Trim(coding, " \t")
+			if !isToken(coding) {
+				return fmt.Errorf("invalid Transfer-Encoding: %q", v)
+			}
+			codings = append(codings, strings.ToLower(coding))
+		}
+	}
+	if codings[len(codings)-1] != "chunked" {
+		return fmt.Errorf("Transfer-Encoding does not end with chunked: %s", strings.Join(values, ", "))
+	}
+	for _, coding := range codings[:len(codings)-1] {
+		if coding == "chunked" {
+			return fmt.Errorf("chunked applied more than once: %s", strings.Join(values, ", "))
+		}
+	}
+	if len(codings) > 1 {
+		return newParseError(StatusNotImplemented, "unsupported Transfer-Encoding: %s", strings.Join(values, ", "))
+	}
+	return nil
+}
+
+// indexCRLF returns the index of the first CRLF in data, or -1 if there is
+// none yet. A LF without a CR in front is an error: servers that disagree
+// on line endings disagree on where a request ends.
+func indexCRLF(data []byte) (int, error) {
+	idx := bytes.IndexByte(data, '\n')
+	if idx == -1 {
+		return -1, nil
+	}
+	if idx == 0 || data[idx-1] != '\r' {
+		return 0, fmt.Errorf("bare LF in request")
+	}
+	return idx - 1, nil
+}
+
+// parseChunkSize parses a chunk-size line, validating and discarding any
+// chunk extensions: chunk-size *( BWS ";" BWS name [ BWS "=" BWS value ] )
+func parseChunkSize(line string) (int, error) {
+	sizeStr, extensions, hasExtensions := strings.Cut(line, ";")
+	if hasExtensions {
+		// BWS is only allowed before a chunk extension
+		sizeStr = strings.TrimRight(sizeStr, " \t")
+	}
+	if sizeStr == "" || len(sizeStr) > 15 {
+		return 0, fmt.Errorf("invalid chunk size: %q", sizeStr)
+	}
+	size := 0
+	for _, c := range []byte(sizeStr) {
+		if !isHex(c) {
+			return 0, fmt.Errorf("invalid chunk size: %q", sizeStr)
+		}
+		size = size*16 + int(unhex(c))
+	}
+
+	if extensions == "" {
+		return size, nil
+	}
+	for _, ext := range strings.Split(extensions, ";") {
+		name, value, hasValue := strings.Cut(ext, "=")
+		name = strings.Trim(name, " \t")
+		if name == "" || !isToken(name) {
+			return 0, fmt.Errorf("invalid chunk extension: %q", ext)
+		}
+		if !hasValue {
+			continue
+		}
+		value = strings.Trim(value, " \t")
+		if !isToken(value) && !isQuotedString(value) {
+			return 0, fmt.Errorf("invalid chunk extension value: %q", ext)
+		}
+	}
+	return size, nil
+}
+
+func isToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range []byte(s) {
+		if c <= ' ' || c >= 0x7f || strings.IndexByte("\"(),/:;<=>?@[\\]{}", c) != -1 {
+			return false
+		}
+	}
+	return true
+}
+
+func isQuotedString(s string) bool {
+	if len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' {
+		return false
+	}
+	escaped := false
+	for _, c := range []byte(s[1 : len(s)-1]) {
+		switch {
+		case escaped:
+			escaped = false
+		case c == '\\':
+			escaped = true
+		case c == '"':
+			return false
+		}
+	}
+	return !escaped
+}
@@ -1,6 +1,9 @@
 package request
 
 import (
+	"context"
+	"errors"
+	"httpfromtcp/internal/headers"
 	"io"
 	"strings"
 	"testing"
@@ -90,9 +93,9 @@ func TestHeaderLinesParse(t *testing.T) {
 	r, err := RequestFromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Equal(t, "localhost:42069", r.Headers["host"])
-	assert.Equal(t, "curl/7.81.0", r.Headers["user-agent"])
-	assert.Equal(t, "*/*", r.Headers["accept"])
+	assert.Equal(t, []string{"localhost:42069"}, r.Headers.Values("host"))
+	assert.Equal(t, []string{"curl/7.81.0"}, r.Headers.Values("user-agent"))
+	assert.Equal(t, []string{"*/*"}, r.Headers.Values("accept"))
 
 	// Test: Malformed Header
 	reader = &chunkReader{
@@ -104,13 +107,13 @@ func TestHeaderLinesParse(t *testing.T) {
 
 	// Test: Empty Headers
 	reader = &chunkReader{
-		data:            "GET / HTTP/1.1\r\n\r\n",
+		data:            "GET / HTTP/1.0\r\n\r\n",
 		numBytesPerRead: 3,
 	}
 	r, err = RequestFromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Empty(t, r.Headers)
+	assert.Equal(t, 0, r.Headers.Len())
 
 	// Test: Malformed Header
 	reader = &chunkReader{
@@ -122,13 +125,21 @@ func TestHeaderLinesParse(t *testing.T) {
 
 	// Test: Duplicate Headers
 	reader = &chunkReader{
-		data:            "GET / HTTP/1.1\r\nHost: localhost:42069\r\nHost: duplicate:8080\r\n\r\n",
+		data:            "GET / HTTP/1.1\r\nHost: localhost:42069\r\nAccept: text/html\r\nAccept: */*\r\n\r\n",
 		numBytesPerRead: 3,
 	}
 	r, err = RequestFromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Equal(t, "localhost:42069, duplicate:8080", r.Headers["host"])
+	assert.Equal(t, []string{"text/html", "*/*"}, r.Headers.Values("accept"))
+
+	// Test: Duplicate Host
+	reader = &chunkReader{
+		data:            "GET / HTTP/1.1\r\nHost: localhost:42069\r\nHost: duplicate:8080\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err = RequestFromReader(reader)
+	require.Error(t, err)
 
 	// Test: Case Insensitive Headers
 	reader = &chunkReader{
@@ -138,8 +149,8 @@ func TestHeaderLinesParse(t *testing.T) {
 	r, err = RequestFromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Equal(t, "localhost:42069", r.Headers["host"])
-	assert.Equal(t, "curl/7.81.0", r.Headers["user-agent"])
+	assert.Equal(t, []string{"localhost:42069"}, r.Headers.Values("host"))
+	assert.Equal(t, []string{"curl/7.81.0"}, r.Headers.Values("user-agent"))
 
 	// Test: Missing End of Headers
 	reader = &chunkReader{
@@ -202,4 +213,376 @@ func TestBodyParse(t *testing.T) {
 	require.NoError(t, err)
 	require.NotNil(t, r)
 	assert.Equal(t, "", string(r.Body))
-}
\ No newline at end of file
+}
+func TestPersistentConnectionParse(t *testing.T) {
+	// Test: Two requests back to back on one connection
+	reader := NewReader(&chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Content-Length: 5\r\n" +
+			"\r\n" +
+			"hello" +
+			"GET /coffee HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Connection: close\r\n" +
+			"\r\n",
+		numBytesPerRead: 7,
+	})
+	r, err := reader.ReadRequest()
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	assert.Equal(t, "/submit", r.RequestLine.RequestTarget)
+	assert.Equal(t, "hello", string(r.Body))
+	assert.True(t, r.KeepAlive())
+
+	r, err = reader.ReadRequest()
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	assert.Equal(t, "/coffee", r.RequestLine.RequestTarget)
+	assert.Equal(t, "", string(r.Body))
+	assert.False(t, r.KeepAlive())
+
+	// Test: Connection closed in between requests
+	_, err = reader.ReadRequest()
+	require.ErrorIs(t, err, io.EOF)
+
+	// Test: Connection closed in the middle of a request
+	reader = NewReader(&chunkReader{
+		data:            "GET / HTTP/1.1\r\nHost: localhost:42069\r\n\r\nGET / HTTP/1.1\r\nHost: localhost\r\n",
+		numBytesPerRead: 1024,
+	})
+	_, err = reader.ReadRequest()
+	require.NoError(t, err)
+	_, err = reader.ReadRequest()
+	require.Error(t, err)
+	assert.NotErrorIs(t, err, io.EOF)
+}
+
+func TestReaderBuffered(t *testing.T) {
+	// Test: Bytes past the request are left in the buffer
+	reader := NewReader(strings.NewReader("GET /chat HTTP/1.1\r\nHost: localhost\r\nUpgrade: chat\r\n\r\nhello"))
+	r, err := reader.ReadRequestHeaders()
+	require.NoError(t, err)
+	assert.Equal(t, "/chat", r.RequestLine.RequestTarget)
+	assert.Equal(t, "hello", string(reader.Buffered()))
+
+	// Test: A request has a context even if nobody set one
+	require.NotNil(t, r.Context())
+	type key struct{}
+	ctx := context.WithValue(r.Context(), key{}, "value")
+	r.SetContext(ctx)
+	assert.Equal(t, ctx, r.Context())
+}
+
+func TestChunkedBodyParse(t *testing.T) {
+	// Test: Chunked body
+	reader := &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"6\r\nhello \r\n" +
+			"7\r\nworld!\n\r\n" +
+			"0\r\n" +
+			"\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err := RequestFromReader(reader)
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	assert.Equal(t, "hello world!\n", string(r.Body))
+	assert.Equal(t, 0, r.Trailers.Len())
+
+	// Test: Chunk extensions and trailers
+	reader = &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"A;name=value;flag\r\n0123456789\r\n" +
+			"1 ; quoted=\"a b\"\r\n!\r\n" +
+			"0\r\n" +
+			"X-Checksum: abc\r\n" +
+			"\r\n",
+		numBytesPerRead: 5,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	assert.Equal(t, "0123456789!", string(r.Body))
+	assert.Equal(t, []string{"abc"}, r.Trailers.Values("x-checksum"))
+
+	// Test: Chunked request followed by another request
+	conn := NewReader(&chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"3\r\nabc\r\n0\r\n\r\n" +
+			"GET /next HTTP/1.1\r\nHost: localhost\r\n\r\n",
+		numBytesPerRead: 1024,
+	})
+	r, err = conn.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "abc", string(r.Body))
+	r, err = conn.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "/next", r.RequestLine.RequestTarget)
+
+	// Test: Both Content-Length and Transfer-Encoding
+	reader = &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost\r\n" +
+			"Content-Length: 3\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"3\r\nabc\r\n0\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	_, err = RequestFromReader(reader)
+	require.Error(t, err)
+
+	// Test: Invalid chunk size
+	_, err = RequestFromReader(strings.NewReader("POST /submit HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\nzz\r\nabc\r\n0\r\n\r\n"))
+	require.Error(t, err)
+
+	// Test: Chunk data longer than its size
+	_, err = RequestFromReader(strings.NewReader("POST /submit HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n2\r\nabc\r\n0\r\n\r\n"))
+	require.Error(t, err)
+
+	// Test: Missing last chunk
+	_, err = RequestFromReader(strings.NewReader("POST /submit HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nabc\r\n"))
+	require.Error(t, err)
+}
+
+func TestStreamingBodyParse(t *testing.T) {
+	// Test: Body is pulled from the connection lazily
+	reader := NewReader(&chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Content-Length: 13\r\n" +
+			"\r\n" +
+			"hello world!\n",
+		numBytesPerRead: 3,
+	})
+	r, err := reader.ReadRequestHeaders()
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	assert.Nil(t, r.Body)
+	body, err := io.ReadAll(r.BodyReader)
+	require.NoError(t, err)
+	assert.Equal(t, "hello world!\n", string(body))
+	require.NoError(t, r.BodyReader.Close())
+
+	// Test: Chunked body with trailers
+	reader = NewReader(&chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"6\r\nhello \r\n7\r\nworld!\n\r\n0\r\nX-Checksum: abc\r\n\r\n",
+		numBytesPerRead: 4,
+	})
+	r, err = reader.ReadRequestHeaders()
+	require.NoError(t, err)
+	body, err = io.ReadAll(r.BodyReader)
+	require.NoError(t, err)
+	assert.Equal(t, "hello world!\n", string(body))
+	assert.Equal(t, []string{"abc"}, r.Trailers.Values("x-checksum"))
+
+	// Test: Unread body is skipped before the next request
+	reader = NewReader(&chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost\r\n" +
+			"Content-Length: 13\r\n" +
+			"\r\n" +
+			"hello world!\n" +
+			"POST /chunked HTTP/1.1\r\n" +
+			"Host: localhost\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"5\r\nhello\r\n0\r\n\r\n" +
+			"GET /next HTTP/1.1\r\nHost: localhost\r\n\r\n",
+		numBytesPerRead: 5,
+	})
+	r, err = reader.ReadRequestHeaders()
+	require.NoError(t, err)
+	buf := make([]byte, 4)
+	n, err := io.ReadFull(r.BodyReader, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "hell", string(buf[:n]))
+	r, err = reader.ReadRequestHeaders()
+	require.NoError(t, err)
+	assert.Equal(t, "/chunked", r.RequestLine.RequestTarget)
+	require.NoError(t, r.BodyReader.Close())
+	_, err = r.BodyReader.Read(buf)
+	require.Error(t, err)
+	r, err = reader.ReadRequestHeaders()
+	require.NoError(t, err)
+	assert.Equal(t, "/next", r.RequestLine.RequestTarget)
+
+	// Test: Connection closed in the middle of the body
+	reader = NewReader(strings.NewReader("POST /submit HTTP/1.1\r\nHost: localhost\r\nContent-Length: 20\r\n\r\npartial content"))
+	r, err = reader.ReadRequestHeaders()
+	require.NoError(t, err)
+	_, err = io.ReadAll(r.BodyReader)
+	require.Error(t, err)
+	require.Error(t, r.BodyReader.Close())
+}
+
+func TestParseErrorStatus(t *testing.T) {
+	tests := []struct {
+		name       string
+		data       string
+		statusCode int
+	}{
+		{"malformed request line", "/coffee HTTP/1.1\r\nHost: localhost\r\n\r\n", StatusBadRequest},
+		{"invalid method", "get / HTTP/1.1\r\nHost: localhost\r\n\r\n", StatusBadRequest},
+		{"malformed version", "GET / HTTP/1.1.1\r\n\r\n", StatusBadRequest},
+		{"unsupported version", "GET / HTTP/2.0\r\n\r\n", StatusHTTPVersionNotSupported},
+		{"malformed header", "GET / HTTP/1.1\r\nHost localhost\r\n\r\n", StatusBadRequest},
+		{"control character in header", "GET / HTTP/1.1\r\nHost: localhost\r\nX-Note: a\x00b\r\n\r\n", StatusBadRequest},
+		{"obs-fold", "GET / HTTP/1.1\r\nHost: localhost\r\nX-Note: a\r\n b\r\n\r\n", StatusBadRequest},
+		{"malformed content length", "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: abc\r\n\r\n", StatusBadRequest},
+		{"unsupported transfer coding", "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: gzip, chunked\r\n\r\n", StatusNotImplemented},
+		{"bad chunk size", "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\nxyz\r\n", StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := RequestFromReader(strings.NewReader(tt.data))
+			var parseErr *ParseError
+			require.ErrorAs(t, err, &parseErr)
+			assert.Equal(t, tt.statusCode, parseErr.StatusCode)
+		})
+	}
+
+	// Test: Connection closed early is not a parse error
+	_, err := RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: localhost"))
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	var parseErr *ParseError
+	assert.False(t, errors.As(err, &parseErr))
+}
+
+func TestRequestLimits(t *testing.T) {
+	statusOf := func(t *testing.T, limits Limits, data string) int {
+		t.Helper()
+		reader := NewReader(&chunkReader{data: data, numBytesPerRead: 16})
+		reader.Limits = limits
+		_, err := reader.ReadRequest()
+		if err == nil {
+			return 0
+		}
+		var parseErr *ParseError
+		require.ErrorAs(t, err, &parseErr)
+		return parseErr.StatusCode
+	}
+
+	// Test: Request line at and over the limit
+	limits := Limits{MaxRequestLineBytes: 20}
+	assert.Equal(t, 0, statusOf(t, limits, "GET /abcdef HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	assert.Equal(t, StatusURITooLong, statusOf(t, limits, "GET /abcdefg HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	assert.Equal(t, StatusURITooLong, statusOf(t, limits, "GET /"+strings.Repeat("a", 100)))
+
+	// Test: Header section over the limit
+	limits = Limits{MaxHeaderBytes: 32}
+	assert.Equal(t, 0, statusOf(t, limits, "GET / HTTP/1.1\r\nHost: localhost:42069\r\n\r\n"))
+	assert.Equal(t, StatusRequestHeaderFieldsTooLarge, statusOf(t, limits, "GET / HTTP/1.1\r\nHost: localhost:42069\r\nAccept: */*\r\n\r\n"))
+	assert.Equal(t, StatusRequestHeaderFieldsTooLarge, statusOf(t, limits, "GET / HTTP/1.1\r\nHost: localhost\r\nX-Long: "+strings.Repeat("a", 100)))
+
+	// Test: Too many header lines
+	limits = Limits{MaxHeaderCount: 3}
+	assert.Equal(t, 0, statusOf(t, limits, "GET / HTTP/1.1\r\nHost: localhost\r\nA: 1\r\nB: 2\r\n\r\n"))
+	assert.Equal(t, StatusRequestHeaderFieldsTooLarge, statusOf(t, limits, "GET / HTTP/1.1\r\nHost: localhost\r\nA: 1\r\nB: 2\r\nC: 3\r\n\r\n"))
+
+	// Test: Trailers count against the header limits
+	assert.Equal(t, StatusRequestHeaderFieldsTooLarge, statusOf(t, limits, "POST / HTTP/1.1\r\nHost: localhost\r\nA: 1\r\nTransfer-Encoding: chunked\r\n\r\n0\r\nD: 4\r\n\r\n"))
+
+	// Test: Body over the limit
+	limits = Limits{MaxBodyBytes: 5}
+	assert.Equal(t, 0, statusOf(t, limits, "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5\r\n\r\nhello"))
+	assert.Equal(t, StatusRequestEntityTooLarge, statusOf(t, limits, "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 6\r\n\r\nhello!"))
+	assert.Equal(t, StatusRequestEntityTooLarge, statusOf(t, limits, "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nhel\r\n3\r\nlo!\r\n0\r\n\r\n"))
+
+	// Test: Negative limits turn the check off
+	limits = Limits{MaxRequestLineBytes: -1}
+	assert.Equal(t, 0, statusOf(t, limits, "GET /"+strings.Repeat("a", 10000)+" HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+
+	// Test: Body too large is reported with the headers when streaming
+	reader := NewReader(strings.NewReader("POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 6\r\n\r\nhello!"))
+	reader.MaxBodyBytes = 5
+	_, err := reader.ReadRequestHeaders()
+	var parseErr *ParseError
+	require.ErrorAs(t, err, &parseErr)
+	assert.Equal(t, StatusRequestEntityTooLarge, parseErr.StatusCode)
+}
+
+func TestHTTP10Parse(t *testing.T) {
+	// Test: HTTP/1.0 without Host closes by default
+	r, err := RequestFromReader(strings.NewReader("GET / HTTP/1.0\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "1.0", r.RequestLine.HttpVersion)
+	assert.False(t, r.KeepAlive())
+
+	// Test: HTTP/1.0 keep-alive
+	r, err = RequestFromReader(strings.NewReader("GET / HTTP/1.0\r\nConnection: Keep-Alive\r\n\r\n"))
+	require.NoError(t, err)
+	assert.True(t, r.KeepAlive())
+
+	// Test: HTTP/1.0 body with Content-Length
+	r, err = RequestFromReader(strings.NewReader("POST / HTTP/1.0\r\nContent-Length: 5\r\n\r\nhello"))
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(r.Body))
+
+	// Test: HTTP/1.0 can't use chunked coding
+	_, err = RequestFromReader(strings.NewReader("POST / HTTP/1.0\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n"))
+	var parseErr *ParseError
+	require.ErrorAs(t, err, &parseErr)
+	assert.Equal(t, StatusBadRequest, parseErr.StatusCode)
+
+	// Test: Other versions are not supported
+	for _, version := range []string{"0.9", "1.2", "2.0", "3.0", "2", "3"} {
+		_, err = RequestFromReader(strings.NewReader("GET / HTTP/" + version + "\r\n\r\n"))
+		require.ErrorAs(t, err, &parseErr)
+		assert.Equal(t, StatusHTTPVersionNotSupported, parseErr.StatusCode)
+	}
+
+	// Test: A bare major version below 2 is still malformed
+	for _, version := range []string{"1", "0", "22"} {
+		_, err = RequestFromReader(strings.NewReader("GET / HTTP/" + version + "\r\n\r\n"))
+		require.ErrorAs(t, err, &parseErr)
+		assert.Equal(t, StatusBadRequest, parseErr.StatusCode)
+	}
+}
+
+func TestObsFoldParse(t *testing.T) {
+	// Test: Folded lines are unfolded when allowed
+	reader := NewReader(strings.NewReader(
+		"GET / HTTP/1.1\r\n" +
+			"Host: localhost\r\n" +
+			"X-Note: one\r\n" +
+			"\t two\r\n" +
+			"Accept: */*\r\n" +
+			"\r\n",
+	))
+	reader.ObsFold = headers.ObsFoldUnfold
+	r, err := reader.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"one two"}, r.Headers.Values("x-note"))
+	assert.Equal(t, []string{"*/*"}, r.Headers.Values("accept"))
+
+	// Test: Folded trailers are unfolded too
+	reader = NewReader(strings.NewReader(
+		"POST / HTTP/1.1\r\n" +
+			"Host: localhost\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"0\r\n" +
+			"X-Checksum: abc\r\n" +
+			" def\r\n" +
+			"\r\n",
+	))
+	reader.ObsFold = headers.ObsFoldUnfold
+	r, err = reader.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"abc def"}, r.Trailers.Values("x-checksum"))
+}
@@ -0,0 +1,99 @@
+package request
+
+import (
+	"httpfromtcp/internal/headers"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// smuggled is the request a front end and this server would disagree about,
+// hidden in the body of the first one.
+const smuggled = "GET /admin HTTP/1.1\r\nHost: localhost\r\n\r\n"
+
+func TestRequestSmuggling(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		// CL.TE: the front end uses Content-Length, we would use chunked
+		{"CL.TE", "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 45\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n" + smuggled},
+		// TE.CL: the front end uses chunked, we would use Content-Length
+		{"TE.CL", "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\nContent-Length: 4\r\n\r\n29\r\n" + smuggled + "\r\n0\r\n\r\n"},
+		{"CL.CL conflicting", "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 0\r\nContent-Length: 41\r\n\r\n" + smuggled},
+		{"CL.CL identical", "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5\r\nContent-Length: 5\r\n\r\nhello"},
+		{"CL list", "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5, 5\r\n\r\nhello"},
+		{"CL plus sign", "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: +5\r\n\r\nhello"},
+		{"CL minus sign", "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: -5\r\n\r\nhello"},
+		{"CL hex", "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 0x5\r\n\r\nhello"},
+		{"CL exponent", "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5e0\r\n\r\nhello"},
+		{"CL inner space", "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5 5\r\n\r\nhello"},
+		{"CL empty", "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length:\r\n\r\n"},
+		{"CL overflow", "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 99999999999999999999\r\n\r\n"},
+		{"TE.TE not final", "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\nTransfer-Encoding: identity\r\n\r\n0\r\n\r\n" + smuggled},
+		{"TE.TE list not final", "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked, identity\r\n\r\n0\r\n\r\n" + smuggled},
+		{"TE.TE chunked twice", "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n"},
+		{"TE.TE unknown coding", "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: xchunked\r\n\r\n0\r\n\r\n"},
+		{"TE.TE parameter", "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked;q=1\r\n\r\n0\r\n\r\n"},
+		{"TE.TE empty element", "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: ,chunked\r\n\r\n0\r\n\r\n"},
+		{"TE.TE space before colon", "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding : chunked\r\n\r\n0\r\n\r\n"},
+		{"TE.TE tab before colon", "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding\t: chunked\r\n\r\n0\r\n\r\n"},
+		{"TE.TE vertical tab", "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: \x0bchunked\r\n\r\n0\r\n\r\n"},
+		{"TE.TE obs-fold", "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding:\r\n chunked\r\n\r\n0\r\n\r\n"},
+		{"TE.TE whitespace first line", "POST / HTTP/1.1\r\n Transfer-Encoding: chunked\r\nHost: localhost\r\nContent-Length: 5\r\n\r\n0\r\n\r\n" + smuggled},
+		{"TE.TE tab first line", "POST / HTTP/1.1\r\n\tTransfer-Encoding: chunked\r\nHost: localhost\r\n\r\n0\r\n\r\n" + smuggled},
+		{"whitespace first trailer", "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n Content-Length: 5\r\n\r\n"},
+		{"TE in HTTP/1.0", "POST / HTTP/1.0\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n"},
+		{"bare LF request line", "GET / HTTP/1.1\nHost: localhost\r\n\r\n"},
+		{"bare LF header", "GET / HTTP/1.1\r\nHost: localhost\nContent-Length: 41\r\n\r\n" + smuggled},
+		{"bare LF end of headers", "GET / HTTP/1.1\r\nHost: localhost\r\n\n" + smuggled},
+		{"bare LF chunk size", "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n5\nhello\r\n0\r\n\r\n"},
+		{"bare CR header", "GET / HTTP/1.1\r\nHost: localhost\rContent-Length: 41\r\n\r\n" + smuggled},
+		{"chunk size trailing space", "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n5 \r\nhello\r\n0\r\n\r\n"},
+		{"chunk size trailing tab", "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n5\t\r\nhello\r\n0\r\n\r\n"},
+		{"last chunk trailing space", "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n0 \r\n\r\n" + smuggled},
+		{"chunk size overflow", "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n10000000000000000001\r\nx\r\n0\r\n\r\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := RequestFromReader(strings.NewReader(tt.data))
+			var parseErr *ParseError
+			require.ErrorAs(t, err, &parseErr)
+			assert.Equal(t, StatusBadRequest, parseErr.StatusCode)
+		})
+	}
+
+	// Test: Whitespace before the first field line is rejected even when
+	// obs-fold is unfolded, there is no line to continue
+	for _, data := range []string{
+		"POST / HTTP/1.1\r\n Transfer-Encoding: chunked\r\nHost: localhost\r\nContent-Length: 5\r\n\r\n0\r\n\r\n" + smuggled,
+		"POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n Content-Length: 5\r\n\r\n",
+	} {
+		reader := NewReader(strings.NewReader(data))
+		reader.ObsFold = headers.ObsFoldUnfold
+		_, err := reader.ReadRequest()
+		var parseErr *ParseError
+		require.ErrorAs(t, err, &parseErr, "%q", data)
+		assert.Equal(t, StatusBadRequest, parseErr.StatusCode)
+	}
+
+	// Test: A known but unsupported coding before chunked is not a framing error
+	_, err := RequestFromReader(strings.NewReader("POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: gzip, chunked\r\n\r\n0\r\n\r\n"))
+	var parseErr *ParseError
+	require.ErrorAs(t, err, &parseErr)
+	assert.Equal(t, StatusNotImplemented, parseErr.StatusCode)
+
+	// Test: Strict framing still accepts well-formed requests
+	reader := NewReader(strings.NewReader(
+		"POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 005\r\n\r\nhello" +
+			"POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: Chunked\r\n\r\n5\r\nworld\r\n0\r\n\r\n",
+	))
+	r, err := reader.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(r.Body))
+	r, err = reader.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "world", string(r.Body))
+}
@@ -0,0 +1,191 @@
+package request
+
+import (
+	"fmt"
+	"strings"
+)
+
+// TargetForm is one of the four request-target forms from RFC 9112.
+type TargetForm int
+
+const (
+	// TargetOrigin is an absolute path with an optional query: /where?q=now
+	TargetOrigin TargetForm = iota
+	// TargetAbsolute is a full URI, as sent to proxies: http://host/where
+	TargetAbsolute
+	// TargetAuthority is host:port, only used by CONNECT
+	TargetAuthority
+	// TargetAsterisk is "*", only used by a server-wide OPTIONS
+	TargetAsterisk
+)
+
+// URL is the parsed request-target.
+type URL struct {
+	Form TargetForm
+	// Scheme and Host are set for the absolute form, Host alone for the
+	// authority form.
+	Scheme string
+	Host   string
+	// Path is percent-decoded, RawPath is the path as it was sent. Both
+	// are empty for the authority and asterisk forms.
+	Path     string
+	RawPath  string
+	RawQuery string
+}
+
+// Values maps a query parameter name to all of its values, in order.
+type Values map[string][]string
+
+// Get returns the first value for key, or "" if there is none.
+func (v Values) Get(key string) string {
+	if values := v[key]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// Query parses RawQuery. Malformed pairs are skipped.
+func (u *URL) Query() Values {
+	values := Values{}
+	for _, pair := range strings.Split(u.RawQuery, "&") {
+		if pair == "" {
+			continue
+		}
+		key, value, _ := strings.Cut(pair, "=")
+		key, err := unescape(key, true)
+		if err != nil {
+			continue
+		}
+		value, err = unescape(value, true)
+		if err != nil {
+			continue
+		}
+		values[key] = append(values[key], value)
+	}
+	return values
+}
+
+// parseRequestTarget parses the target of a request with the given method.
+// The asterisk form is only allowed for OPTIONS, and CONNECT only takes
+// the authority form.
+func parseRequestTarget(method, target string) (*URL, error) {
+	for _, c := range []byte(target) {
+		if c <= ' ' || c >= 0x7f {
+			return nil, fmt.Errorf("invalid character in request-target: %q", target)
+		}
+	}
+	if strings.Contains(target, "#") {
+		return nil, fmt.Errorf("fragment in request-target: %s", target)
+	}
+
+	if method == "CONNECT" {
+		// authority-form is host ":" port, the port is not optional
+		port := target[len(stripPort(target)):]
+		if target == "" || !validHost(target) || len(port) < 2 {
+			return nil, fmt.Errorf("CONNECT needs an authority-form target: %s", target)
+		}
+		return &URL{Form: TargetAuthority, Host: target}, nil
+	}
+
+	if target == "*" {
+		if method != "OPTIONS" {
+			return nil, fmt.Errorf("asterisk-form target is only allowed for OPTIONS")
+		}
+		return &URL{Form: TargetAsterisk}, nil
+	}
+
+	u := &URL{Form: TargetOrigin}
+	rest := target
+	if !strings.HasPrefix(target, "/") {
+		scheme, afterScheme, found := strings.Cut(target, "://")
+		if !found || !validScheme(scheme) {
+			return nil, fmt.Errorf("invalid request-target: %s", target)
+		}
+		host := afterScheme
+		rest = ""
+		if idx := strings.IndexAny(afterScheme, "/?"); idx != -1 {
+			host = afterScheme[:idx]
+			rest = afterScheme[idx:]
+		}
+		if host == "" || !validHost(host) {
+			return nil, fmt.Errorf("invalid authority in request-target: %s", target)
+		}
+		if !strings.HasPrefix(rest, "/") {
+			rest = "/" + rest
+		}
+		u.Form = TargetAbsolute
+		u.Scheme = strings.ToLower(scheme)
+		u.Host = host
+	}
+
+	rawPath, rawQuery, _ := strings.Cut(rest, "?")
+	path, err := unescape(rawPath, false)
+	if err != nil {
+		return nil, err
+	}
+	u.Path = path
+	u.RawPath = rawPath
+	u.RawQuery = rawQuery
+	return u, nil
+}
+
+// validScheme checks scheme = ALPHA *( ALPHA / DIGIT / "+" / "-" / "." )
+func validScheme(scheme string) bool {
+	if scheme == "" {
+		return false
+	}
+	for i, c := range []byte(scheme) {
+		isAlpha := c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+		if i == 0 && !isAlpha {
+			return false
+		}
+		if !isAlpha && !(c >= '0' && c <= '9') && c != '+' && c != '-' && c != '.' {
+			return false
+		}
+	}
+	return true
+}
+
+// Unescape decodes the percent-encoding in a path segment.
+func Unescape(s string) (string, error) {
+	return unescape(s, false)
+}
+
+// unescape decodes %XX sequences, and '+' as a space if query is set.
+func unescape(s string, query bool) (string, error) {
+	if !strings.ContainsAny(s, "%+") {
+		return s, nil
+	}
+
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '%':
+			if i+2 >= len(s) || !isHex(s[i+1]) || !isHex(s[i+2]) {
+				return "", fmt.Errorf("invalid percent-encoding: %q", s)
+			}
+			sb.WriteByte(unhex(s[i+1])<<4 | unhex(s[i+2]))
+			i += 2
+		case s[i] == '+' && query:
+			sb.WriteByte(' ')
+		default:
+			sb.WriteByte(s[i])
+		}
+	}
+	return sb.String(), nil
+}
+
+func isHex(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F'
+}
+
+func unhex(c byte) byte {
+	switch {
+	case c >= '0' && c <= '9':
+		return c - '0'
+	case c >= 'a' && c <= 'f':
+		return c - 'a' + 10
+	default:
+		return c - 'A' + 10
+	}
+}
@@ -0,0 +1,101 @@
+package request
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func parseTarget(t *testing.T, method, target string) (*URL, error) {
+	t.Helper()
+	r, err := RequestFromReader(strings.NewReader(method + " " + target + " HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	if err != nil {
+		return nil, err
+	}
+	return r.URL, nil
+}
+
+func TestRequestTargetParse(t *testing.T) {
+	// Test: Origin form with query
+	u, err := parseTarget(t, "GET", "/search/caf%C3%A9%2Fbar?q=go+lang&tag=a&tag=b%26c&empty=&flag")
+	require.NoError(t, err)
+	assert.Equal(t, TargetOrigin, u.Form)
+	assert.Equal(t, "/search/café/bar", u.Path)
+	assert.Equal(t, "/search/caf%C3%A9%2Fbar", u.RawPath)
+	assert.Equal(t, "q=go+lang&tag=a&tag=b%26c&empty=&flag", u.RawQuery)
+	query := u.Query()
+	assert.Equal(t, "go lang", query.Get("q"))
+	assert.Equal(t, []string{"a", "b&c"}, query["tag"])
+	assert.Equal(t, []string{""}, query["empty"])
+	assert.Equal(t, []string{""}, query["flag"])
+	assert.Equal(t, "", query.Get("missing"))
+
+	// Test: Origin form without query
+	u, err = parseTarget(t, "GET", "/")
+	require.NoError(t, err)
+	assert.Equal(t, "/", u.Path)
+	assert.Equal(t, "", u.RawQuery)
+	assert.Empty(t, u.Query())
+
+	// Test: Absolute form
+	u, err = parseTarget(t, "GET", "HTTP://example.com:8080/a%20b?x=1")
+	require.NoError(t, err)
+	assert.Equal(t, TargetAbsolute, u.Form)
+	assert.Equal(t, "http", u.Scheme)
+	assert.Equal(t, "example.com:8080", u.Host)
+	assert.Equal(t, "/a b", u.Path)
+	assert.Equal(t, "x=1", u.RawQuery)
+
+	// Test: Absolute form without a path
+	u, err = parseTarget(t, "GET", "http://example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "example.com", u.Host)
+	assert.Equal(t, "/", u.Path)
+
+	// Test: Authority form for CONNECT
+	u, err = parseTarget(t, "CONNECT", "example.com:443")
+	require.NoError(t, err)
+	assert.Equal(t, TargetAuthority, u.Form)
+	assert.Equal(t, "example.com:443", u.Host)
+	assert.Equal(t, "", u.Path)
+	u, err = parseTarget(t, "CONNECT", "[::1]:443")
+	require.NoError(t, err)
+	assert.Equal(t, "[::1]:443", u.Host)
+
+	// Test: Asterisk form for OPTIONS
+	u, err = parseTarget(t, "OPTIONS", "*")
+	require.NoError(t, err)
+	assert.Equal(t, TargetAsterisk, u.Form)
+
+	// Test: Invalid targets
+	for _, tt := range []struct{ method, target string }{
+		{"GET", "/page#section"},
+		{"GET", "/bad%2"},
+		{"GET", "/bad%zz"},
+		{"GET", "*"},
+		{"GET", "example.com:443"},
+		{"GET", "http:///path"},
+		{"GET", "http://user@example.com/"},
+		{"GET", "1http://example.com/"},
+		{"GET", "http://[::1/"},
+		{"GET", "http://[]/"},
+		{"GET", "http://exa%mple.com/"},
+		{"GET", "http://example.com:99999/"},
+		{"GET", "http://example.com:http/"},
+		{"CONNECT", "/path"},
+		{"CONNECT", "example.com"},
+		{"CONNECT", "example.com:"},
+		{"CONNECT", "user@example.com:443"},
+		{"CONNECT", "example.com:65536"},
+		{"CONNECT", "[::1]"},
+		{"CONNECT", "[::1]:"},
+		{"CONNECT", "[::1:443"},
+	} {
+		_, err = parseTarget(t, tt.method, tt.target)
+		var parseErr *ParseError
+		require.ErrorAs(t, err, &parseErr, "%s %s", tt.method, tt.target)
+		assert.Equal(t, StatusBadRequest, parseErr.StatusCode)
+	}
+}
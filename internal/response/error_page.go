@@ -0,0 +1,24 @@
+package response
+
+import (
+	"fmt"
+	"html"
+)
+
+// ErrorPage returns a plain HTML page for an error status. message is
+// escaped and left out if it is empty.
+func ErrorPage(statusCode StatusCode, message string) []byte {
+	paragraph := ""
+	if message != "" {
+		paragraph = fmt.Sprintf("<p>%s</p>\n", html.EscapeString(message))
+	}
+	return []byte(fmt.Sprintf(`<html>
+<head>
+<title>%d %s</title>
+</head>
+<body>
+<h1>%s</h1>
+%s</body>
+</html>
+`, statusCode, StatusText(statusCode), StatusText(statusCode), paragraph))
+}
@@ -5,10 +5,9 @@ import (
 	"httpfromtcp/internal/headers"
 )
 
-func GetDefaultHeaders(contentLen int) headers.Headers {
+func GetDefaultHeaders(contentLen int) *headers.Headers {
 	headers := headers.NewHeaders()
 	headers.Set("Content-Length", fmt.Sprintf("%d", contentLen))
-	headers.Set("Connection", "close")
 	headers.Set("Content-Type", "text/html")
 	return headers
 }
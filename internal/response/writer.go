@@ -1,40 +1,283 @@
 package response
 
 import (
+	"errors"
 	"fmt"
 	"httpfromtcp/internal/headers"
 	"io"
+	"net"
+	"strconv"
+	"strings"
 )
 
 type writerState int
 
+// A response is written in this order: status line, headers, body, then
+// trailers if the body is chunked. Hijack leaves the order altogether.
 const (
 	writerStateStatusLine writerState = iota
 	writerStateHeaders
 	writerStateBody
+	writerStateTrailers
+	writerStateDone
+	writerStateHijacked
 )
 
+func (s writerState) String() string {
+	switch s {
+	case writerStateStatusLine:
+		return "status line"
+	case writerStateHeaders:
+		return "headers"
+	case writerStateBody:
+		return "body"
+	case writerStateTrailers:
+		return "trailers"
+	case writerStateHijacked:
+		return "hijacked"
+	default:
+		return "done"
+	}
+}
+
+// bufferSize is how much of a body written with Write is held back, so that
+// a short body can go out with a Content-Length.
+const bufferSize = 4096
+
+// ErrWriteOrder is returned when a part of the response is written out of
+// order, e.g. the headers twice or the body after the trailers.
+var ErrWriteOrder = errors.New("response written out of order")
+
+// ErrHijacked is returned when writing a response after its connection
+// was taken over with Hijack.
+var ErrHijacked = errors.New("connection hijacked")
+
+// ErrNotHijackable is returned by Hijack when the writer has no connection
+// that can be taken over.
+var ErrNotHijackable = errors.New("connection can't be hijacked")
+
 type Writer struct {
-	Writer io.Writer
-	state writerState
+	Writer    io.Writer
+	state     writerState
+	keepAlive bool
+	// http10 is set for HTTP/1.0 clients, which don't understand chunked
+	// bodies and need keep-alive spelled out
+	http10 bool
+	// head is set for responses to HEAD requests, which carry the headers
+	// of the body but not the body itself
+	head bool
+	// chunked is set when the body is sent with chunked coding
+	chunked bool
+	// unchunked is set when a chunked body is sent as is to an HTTP/1.0
+	// client and delimited by closing the connection
+	unchunked bool
+	// contentLength is the Content-Length that was sent, or -1
+	contentLength int
+	statusCode    StatusCode
+	bytesWritten  int
+	// header and buf hold the headers and body of a response written with
+	// Write until they are sent
+	header *headers.Headers
+	buf    []byte
+	// trailerNames are the declared trailer fields, trailers the values
+	// set so far
+	trailerNames []string
+	trailers     *headers.Headers
+	hijacker     func() (net.Conn, error)
 }
 
 func NewWriter(w io.Writer) *Writer {
 	writer := &Writer{
-		Writer: w,
-		state: writerStateStatusLine,
+		Writer:        w,
+		state:         writerStateStatusLine,
+		keepAlive:     true,
+		contentLength: -1,
+		header:        headers.NewHeaders(),
+		trailers:      headers.NewHeaders(),
 	}
 	return writer
 }
 
-func (w Writer) WriteStatusLine(statusCode StatusCode) error {
-	_, err :=w.Writer.Write(GetStatusLine(statusCode))
+// SetKeepAlive sets whether the connection should stay open after this
+// response. It has to be called before the headers are written.
+func (w *Writer) SetKeepAlive(keepAlive bool) {
+	w.keepAlive = keepAlive
+}
+
+// SetClientVersion tells the writer the HTTP-version of the request, e.g.
+// "1.0", so the response can be framed in a way the client understands.
+func (w *Writer) SetClientVersion(version string) {
+	w.http10 = version == "1.0"
+}
+
+// SetRequestMethod tells the writer the method of the request. The body
+// of a response to HEAD is accounted for, but not sent.
+func (w *Writer) SetRequestMethod(method string) {
+	w.head = method == "HEAD"
+}
+
+// KeepAlive reports whether the connection can carry another request once
+// this response is done. It is false until the response is complete: all
+// of a Content-Length body written, or a chunked body with its trailers.
+func (w *Writer) KeepAlive() bool {
+	if !w.keepAlive {
+		return false
+	}
+	return w.complete()
+}
+
+// complete reports whether all of the response has been sent.
+func (w *Writer) complete() bool {
+	switch w.state {
+	case writerStateBody:
+		return w.head || (!w.chunked && w.bytesWritten == w.contentLength)
+	case writerStateDone:
+		return true
+	default:
+		return false
+	}
+}
+
+// bodyWriter returns where body bytes go, nowhere for a HEAD response.
+func (w *Writer) bodyWriter() io.Writer {
+	if w.head {
+		return io.Discard
+	}
+	return w.Writer
+}
+
+// StatusCode returns the status code that was written. Before the status
+// line is written it returns the 200 that Finish sends by default, or 0 if
+// the connection was hijacked without a response.
+func (w *Writer) StatusCode() StatusCode {
+	if w.statusCode == 0 && w.state != writerStateHijacked {
+		return StatusOK
+	}
+	return w.statusCode
+}
+
+// BytesWritten returns the number of body bytes written so far, including
+// those buffered by Write, not counting chunk framing.
+func (w *Writer) BytesWritten() int {
+	return w.bytesWritten
+}
+
+// expect checks that the writer is in the given state before writing what.
+func (w *Writer) expect(state writerState, what string) error {
+	if w.state == writerStateHijacked {
+		return ErrHijacked
+	}
+	if w.state != state {
+		return fmt.Errorf("%w: %s written in the %s state, expected %s", ErrWriteOrder, what, w.state, state)
+	}
+	if len(w.buf) > 0 && state != writerStateStatusLine {
+		return fmt.Errorf("%w: %s written while Write has a body buffered", ErrWriteOrder, what)
+	}
+	return nil
+}
+
+func (w *Writer) WriteStatusLine(statusCode StatusCode) error {
+	return w.WriteStatusLineReason(statusCode, StatusText(statusCode))
+}
+
+// WriteStatusLineReason writes a status line with a custom reason phrase,
+// for nonstandard codes or a localized phrase.
+func (w *Writer) WriteStatusLineReason(statusCode StatusCode, reasonPhrase string) error {
+	if err := w.expect(writerStateStatusLine, "status line"); err != nil {
+		return err
+	}
+	if statusCode < 100 || statusCode > 999 {
+		return fmt.Errorf("invalid status code: %d", statusCode)
+	}
+	if !headers.ValidFieldValue(reasonPhrase) {
+		return fmt.Errorf("invalid reason phrase: %q", reasonPhrase)
+	}
+	_, err := w.Writer.Write(GetStatusLineReason(statusCode, reasonPhrase))
+	w.statusCode = statusCode
+	w.state = writerStateHeaders
 	return err
 }
 
-func (w Writer) WriteHeaders(headers headers.Headers) error {
-	for k, v := range headers {
-		_, err := fmt.Fprintf(w.Writer, "%s: %s\r\n", k, v)
+// WriteHeaders writes the header section. Fields with an invalid name or
+// a value containing CR, LF or other control characters are refused before
+// anything is written, so a handler can't inject extra field lines.
+func (w *Writer) WriteHeaders(h *headers.Headers) error {
+	if err := w.expect(writerStateHeaders, "headers"); err != nil {
+		return err
+	}
+	if err := validateFields(h); err != nil {
+		return err
+	}
+	contentLength := -1
+	if v, ok := h.Get("Content-Length"); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid Content-Length: %q", v)
+		}
+		contentLength = n
+	}
+	if w.statusCode < 200 && w.statusCode != StatusSwitchingProtocols {
+		// an interim response, the final one follows
+		w.state = writerStateStatusLine
+		return w.writeFields(h)
+	}
+	if err := w.addTrailerNames(h.Values("Trailer")); err != nil {
+		return err
+	}
+	if h.ContainsToken("Connection", "close") {
+		w.keepAlive = false
+	}
+	chunked := h.ContainsToken("Transfer-Encoding", "chunked")
+	if !bodyAllowed(w.statusCode) {
+		// the response ends with the header section, whatever it says.
+		// A 304 keeps Content-Length, it describes the cached content.
+		h.Del("Transfer-Encoding")
+		h.Del("Trailer")
+		if w.statusCode != StatusNotModified {
+			h.Del("Content-Length")
+		}
+		chunked = false
+		contentLength = 0
+		w.trailerNames = nil
+	}
+	if chunked && w.http10 {
+		// HTTP/1.0 has no chunked coding, send the body as is
+		h.Del("Transfer-Encoding")
+		h.Del("Trailer")
+		w.unchunked = true
+		chunked = false
+	}
+	if chunked {
+		// chunked coding wins over a Content-Length
+		h.Del("Content-Length")
+		contentLength = -1
+		if len(w.trailerNames) > 0 {
+			h.Set("Trailer", strings.Join(w.trailerNames, ", "))
+		}
+	} else if len(w.trailerNames) > 0 && !w.unchunked && bodyAllowed(w.statusCode) {
+		return fmt.Errorf("trailers declared on a response that is not chunked")
+	}
+	if contentLength == -1 && !chunked && !w.head {
+		// the body is delimited by closing the connection
+		w.keepAlive = false
+	}
+	if !w.keepAlive {
+		h.Set("Connection", "close")
+	} else if w.http10 {
+		h.Set("Connection", "keep-alive")
+	}
+
+	w.state = writerStateBody
+	w.chunked = chunked
+	w.contentLength = contentLength
+	return w.writeFields(h)
+}
+
+// writeFields writes a header or trailer section, ending it with an empty
+// line.
+func (w *Writer) writeFields(h *headers.Headers) error {
+	for k, v := range h.All() {
+		_, err := fmt.Fprintf(w.Writer, "%s: %s\r\n", headers.CanonicalKey(k), v)
 		if err != nil {
 			return err
 		}
@@ -43,36 +286,392 @@ func (w Writer) WriteHeaders(headers headers.Headers) error {
 	return err
 }
 
-func (w Writer) WriteBody(b []byte) error {
-	_, err := fmt.Fprintf(w.Writer, "%s", b)
+// writeImplicitHeaders writes a 200 status line and the default headers
+// for a handler that went straight to the body.
+func (w *Writer) writeImplicitHeaders(h *headers.Headers) error {
+	if w.state == writerStateStatusLine {
+		if err := w.WriteStatusLine(StatusOK); err != nil {
+			return err
+		}
+	}
+	if w.state == writerStateHeaders {
+		return w.WriteHeaders(h)
+	}
+	return nil
+}
+
+// WriteBody writes body bytes. If nothing was written yet, it sends a 200
+// status line and default headers with b as the whole body first.
+func (w *Writer) WriteBody(b []byte) error {
+	if err := w.writeImplicitHeaders(GetDefaultHeaders(len(b))); err != nil {
+		return err
+	}
+	if err := w.expect(writerStateBody, "body"); err != nil {
+		return err
+	}
+	if !bodyAllowed(w.statusCode) {
+		return fmt.Errorf("a %d response can't have a body", w.statusCode)
+	}
+	if w.chunked {
+		return fmt.Errorf("%w: WriteBody on a chunked response, use WriteChunkedBody", ErrWriteOrder)
+	}
+	if w.contentLength != -1 && w.bytesWritten+len(b) > w.contentLength {
+		return fmt.Errorf("body is longer than its Content-Length of %d", w.contentLength)
+	}
+	n, err := fmt.Fprintf(w.bodyWriter(), "%s", b)
+	w.bytesWritten += n
 	return err
 }
 
-func (w Writer) WriteChunkedBody(p []byte) (int, error) {
-	n, err := fmt.Fprintf(w.Writer, "%x\r\n%s\r\n", len(p), p)
+// WriteChunkedBody writes p as one chunk. If nothing was written yet, it
+// sends a 200 status line and default chunked headers first.
+func (w *Writer) WriteChunkedBody(p []byte) (int, error) {
+	h := GetDefaultHeaders(0)
+	h.Del("Content-Length")
+	h.Set("Transfer-Encoding", "chunked")
+	if err := w.writeImplicitHeaders(h); err != nil {
+		return 0, err
+	}
+	if err := w.expect(writerStateBody, "chunk"); err != nil {
+		return 0, err
+	}
+	if !bodyAllowed(w.statusCode) {
+		return 0, fmt.Errorf("a %d response can't have a body", w.statusCode)
+	}
+	if w.unchunked {
+		n, err := fmt.Fprintf(w.bodyWriter(), "%s", p)
+		w.bytesWritten += n
+		return n, err
+	}
+	if !w.chunked {
+		return 0, fmt.Errorf("%w: WriteChunkedBody on a response that is not chunked", ErrWriteOrder)
+	}
+	if len(p) == 0 {
+		// a zero-size chunk would end the body
+		return 0, nil
+	}
+	n, err := w.writeChunk(p)
+	if err != nil {
+		return n, err
+	}
+	w.bytesWritten += len(p)
+
+	return n, nil
+}
+
+func (w *Writer) writeChunk(p []byte) (int, error) {
+	n, err := fmt.Fprintf(w.bodyWriter(), "%x\r\n%s\r\n", len(p), p)
 	if err != nil {
 		return n, fmt.Errorf("error while writing chunk: %v", err)
 	}
-
 	return n, nil
 }
 
+// WriteChunkedBodyDone writes the last chunk. Trailers, if any, follow with
+// WriteTrailers, which also ends the response.
 func (w *Writer) WriteChunkedBodyDone() (int, error) {
-	n, err := fmt.Fprintf(w.Writer, "0\r\n")
+	if err := w.expect(writerStateBody, "last chunk"); err != nil {
+		return 0, err
+	}
+	if !w.chunked && !w.unchunked {
+		return 0, fmt.Errorf("%w: WriteChunkedBodyDone on a response that is not chunked", ErrWriteOrder)
+	}
+	w.state = writerStateTrailers
+	if w.unchunked {
+		return 0, nil
+	}
+	n, err := fmt.Fprintf(w.bodyWriter(), "0\r\n")
 	if err != nil {
 		return n, fmt.Errorf("error while ending writing body: %v", err)
 	}
 	return n, nil
 }
 
-func (w *Writer) WriteTrailers(h headers.Headers) error {
-	for k, v := range h {
-		_, err := fmt.Fprintf(w.Writer, "%s: %s\r\n", k, v)
+// WriteTrailers writes the trailer section and ends a chunked response. h
+// may be empty, but the call is still needed to end the response.
+func (w *Writer) WriteTrailers(h *headers.Headers) error {
+	if err := w.expect(writerStateTrailers, "trailers"); err != nil {
+		return err
+	}
+	if err := validateFields(h); err != nil {
+		return err
+	}
+	for name := range h.All() {
+		if !w.trailerDeclared(name) {
+			return fmt.Errorf("trailer %s was not declared", name)
+		}
+	}
+	w.state = writerStateDone
+	if w.unchunked || w.head {
+		// there is nowhere to put trailers without a chunked body
+		return nil
+	}
+	// values set with SetTrailer go out unless h has its own
+	trailers := headers.NewHeaders()
+	for k, v := range w.trailers.All() {
+		if _, ok := h.Get(k); !ok {
+			trailers.Add(k, v)
+		}
+	}
+	for k, v := range h.All() {
+		trailers.Add(k, v)
+	}
+	return w.writeFields(trailers)
+}
+
+// forbiddenTrailers are fields that can't be sent as trailers: message
+// framing, routing, and fields a recipient needs before the content
+// (RFC 9110, section 6.5.1).
+var forbiddenTrailers = []string{
+	"Authorization", "Cache-Control", "Connection", "Content-Encoding",
+	"Content-Length", "Content-Range", "Content-Type", "Expect", "Host",
+	"Keep-Alive", "Max-Forwards", "Pragma", "Proxy-Authenticate",
+	"Proxy-Authorization", "Range", "Set-Cookie", "TE", "Trailer",
+	"Transfer-Encoding", "Upgrade", "WWW-Authenticate",
+}
+
+// DeclareTrailer announces trailer fields in the Trailer header, their
+// values are set with SetTrailer while the body is written. It has to be
+// called before the headers are sent, and a body written with Write then
+// always goes out chunked.
+func (w *Writer) DeclareTrailer(names ...string) error {
+	if w.state != writerStateStatusLine && w.state != writerStateHeaders {
+		return fmt.Errorf("%w: trailers declared in the %s state", ErrWriteOrder, w.state)
+	}
+	return w.addTrailerNames(names)
+}
+
+// SetTrailer sets the value of a declared trailer field. Trailers are sent
+// when the body ends, on Finish or WriteTrailers.
+func (w *Writer) SetTrailer(name, value string) error {
+	if !w.trailerDeclared(name) {
+		return fmt.Errorf("trailer %s was not declared", name)
+	}
+	if w.state == writerStateDone {
+		return fmt.Errorf("%w: trailer set in the %s state", ErrWriteOrder, w.state)
+	}
+	if w.state >= writerStateBody && !w.chunked && !w.unchunked {
+		return fmt.Errorf("trailer set on a response that is not chunked")
+	}
+	if !headers.ValidFieldValue(value) {
+		return fmt.Errorf("invalid trailer value for %s: %q", name, value)
+	}
+	w.trailers.Set(name, value)
+	return nil
+}
+
+// addTrailerNames declares the trailer fields in comma-separated lists.
+func (w *Writer) addTrailerNames(lists []string) error {
+	for _, list := range lists {
+		for _, name := range strings.Split(list, ",") {
+			name = strings.Trim(name, " \t")
+			if !headers.ValidFieldName(name) {
+				return fmt.Errorf("invalid trailer name: %q", name)
+			}
+			for _, forbidden := range forbiddenTrailers {
+				if strings.EqualFold(name, forbidden) {
+					return fmt.Errorf("%s is not allowed in trailers", name)
+				}
+			}
+			if !w.trailerDeclared(name) {
+				w.trailerNames = append(w.trailerNames, headers.CanonicalKey(name))
+			}
+		}
+	}
+	return nil
+}
+
+func (w *Writer) trailerDeclared(name string) bool {
+	for _, declared := range w.trailerNames {
+		if strings.EqualFold(declared, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Header returns the headers sent with a body written with Write. They can
+// be changed until the body starts going out.
+func (w *Writer) Header() *headers.Headers {
+	return w.header
+}
+
+// Write writes body bytes, implementing io.Writer. Until the headers are
+// written explicitly, the body is buffered: if the response is finished
+// within bufferSize bytes it goes out with a Content-Length, otherwise it
+// switches to chunked coding, or to a body delimited by closing the
+// connection for HTTP/1.0 clients. After explicit headers, Write follows
+// the framing they chose.
+func (w *Writer) Write(p []byte) (int, error) {
+	switch w.state {
+	case writerStateStatusLine, writerStateHeaders:
+		if w.state == writerStateHeaders && !bodyAllowed(w.statusCode) {
+			return 0, fmt.Errorf("a %d response can't have a body", w.statusCode)
+		}
+		w.buf = append(w.buf, p...)
+		w.bytesWritten += len(p)
+		if len(w.buf) > bufferSize {
+			if err := w.startChunked(); err != nil {
+				return 0, err
+			}
+		}
+		return len(p), nil
+	case writerStateBody:
+		var err error
+		if w.chunked || w.unchunked {
+			_, err = w.WriteChunkedBody(p)
+		} else {
+			err = w.WriteBody(p)
+		}
 		if err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	case writerStateHijacked:
+		return 0, ErrHijacked
+	default:
+		return 0, fmt.Errorf("%w: body written in the %s state", ErrWriteOrder, w.state)
+	}
+}
+
+// Finish ends the response, the server calls it when the handler returns.
+// It sends a body buffered by Write, or an empty 200 if nothing was written
+// at all, and ends a chunked body.
+func (w *Writer) Finish() error {
+	if len(w.trailerNames) > 0 && (w.state == writerStateStatusLine ||
+		w.state == writerStateHeaders && bodyAllowed(w.statusCode)) {
+		// only a chunked body can carry the declared trailers
+		if err := w.startChunked(); err != nil {
+			return err
+		}
+	}
+	switch w.state {
+	case writerStateStatusLine, writerStateHeaders:
+		buf := w.buf
+		w.buf = nil
+		h := w.header
+		if w.state == writerStateHeaders && !bodyAllowed(w.statusCode) {
+			// the body was written before the status line said there is
+			// none, drop it
+			w.bytesWritten = 0
+			return w.sendHeader(h, false)
+		}
+		h.Del("Transfer-Encoding")
+		h.Set("Content-Length", strconv.Itoa(len(buf)))
+		if err := w.sendHeader(h, len(buf) > 0); err != nil {
+			return err
+		}
+		_, err := w.bodyWriter().Write(buf)
+		return err
+	case writerStateBody:
+		if !w.chunked && !w.unchunked {
+			return nil
+		}
+		if _, err := w.WriteChunkedBodyDone(); err != nil {
+			return err
+		}
+		return w.WriteTrailers(headers.NewHeaders())
+	case writerStateTrailers:
+		return w.WriteTrailers(headers.NewHeaders())
+	default:
+		return nil
+	}
+}
+
+// Reset drops the headers, trailers and body buffered by Write, so that a different
+// response can be written instead. It fails once anything was sent.
+func (w *Writer) Reset() error {
+	if w.state == writerStateHijacked {
+		return ErrHijacked
+	}
+	if w.statusCode != 0 {
+		return fmt.Errorf("%w: response already sent", ErrWriteOrder)
+	}
+	w.header = headers.NewHeaders()
+	w.trailerNames = nil
+	w.trailers = headers.NewHeaders()
+	w.buf = nil
+	w.bytesWritten = 0
+	return nil
+}
+
+// SetHijacker sets the function Hijack takes the connection over with. The
+// server sets it for every response.
+func (w *Writer) SetHijacker(hijacker func() (net.Conn, error)) {
+	w.hijacker = hijacker
+}
+
+// Hijack takes the connection over from the server, e.g. to speak another
+// protocol after a 101 Switching Protocols. It works before anything was
+// written, or once a response is complete. The server then leaves the
+// connection alone: the caller has to close it, and the server's timeouts
+// and limits no longer apply. Bytes the client sent past the request are
+// read first from the returned connection.
+func (w *Writer) Hijack() (net.Conn, error) {
+	if w.hijacker == nil {
+		return nil, ErrNotHijackable
+	}
+	switch {
+	case w.state == writerStateHijacked:
+		return nil, ErrHijacked
+	case w.state == writerStateStatusLine && len(w.buf) == 0, w.complete():
+	default:
+		return nil, fmt.Errorf("%w: hijacked in the %s state", ErrWriteOrder, w.state)
+	}
+	conn, err := w.hijacker()
+	if err != nil {
+		return nil, err
+	}
+	w.state = writerStateHijacked
+	return conn, nil
+}
+
+// startChunked sends the headers with chunked coding, followed by the
+// buffered body as the first chunk. It's used once the body outgrew the
+// buffer, or to make room for trailers.
+func (w *Writer) startChunked() error {
+	buf := w.buf
+	w.buf = nil
+	h := w.header
+	h.Del("Content-Length")
+	h.Set("Transfer-Encoding", "chunked")
+	if err := w.sendHeader(h, true); err != nil {
+		return err
+	}
+	if len(buf) == 0 {
+		return nil
+	}
+	if w.unchunked {
+		_, err := w.bodyWriter().Write(buf)
+		return err
+	}
+	_, err := w.writeChunk(buf)
+	return err
+}
+
+// sendHeader writes an implicit 200 status line if there is none yet, then
+// the headers of a response written with Write.
+func (w *Writer) sendHeader(h *headers.Headers, hasBody bool) error {
+	if w.state == writerStateStatusLine {
+		if err := w.WriteStatusLine(StatusOK); err != nil {
 			return err
 		}
 	}
+	if _, ok := h.Get("Content-Type"); !ok && hasBody {
+		h.Set("Content-Type", "text/html")
+	}
+	return w.WriteHeaders(h)
+}
 
-	w.Writer.Write([]byte("\r\n"))
+// validateFields checks that every field line in h can be written as is.
+func validateFields(h *headers.Headers) error {
+	for k, v := range h.All() {
+		if !headers.ValidFieldName(k) {
+			return fmt.Errorf("invalid header name: %q", k)
+		}
+		if !headers.ValidFieldValue(v) {
+			return fmt.Errorf("invalid header value for %s: %q", k, v)
+		}
+	}
 	return nil
-}
\ No newline at end of file
+}
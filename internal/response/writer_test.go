@@ -0,0 +1,513 @@
+package response
+
+import (
+	"bytes"
+	"fmt"
+	"httpfromtcp/internal/headers"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func chunkedHeaders() *headers.Headers {
+	h := GetDefaultHeaders(0)
+	h.Del("Content-Length")
+	h.Set("Transfer-Encoding", "chunked")
+	h.Set("Trailer", "X-Checksum")
+	return h
+}
+
+func TestWriterHTTP10(t *testing.T) {
+	// Test: Chunked body is sent as is and closes the connection
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+	w.SetClientVersion("1.0")
+	w.SetKeepAlive(true)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(chunkedHeaders()))
+	_, err := w.WriteChunkedBody([]byte("hello "))
+	require.NoError(t, err)
+	_, err = w.WriteChunkedBody([]byte("world"))
+	require.NoError(t, err)
+	_, err = w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	trailers := headers.NewHeaders()
+	trailers.Set("X-Checksum", "abc")
+	require.NoError(t, w.WriteTrailers(trailers))
+
+	res := buf.String()
+	assert.NotContains(t, res, "Transfer-Encoding")
+	assert.NotContains(t, res, "Trailer")
+	assert.NotContains(t, res, "X-Checksum")
+	assert.Contains(t, res, "Connection: close\r\n")
+	assert.Contains(t, res, "\r\n\r\nhello world")
+	assert.False(t, w.KeepAlive())
+
+	// Test: Keep-alive is spelled out for HTTP/1.0
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	w.SetClientVersion("1.0")
+	w.SetKeepAlive(true)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(2)))
+	require.NoError(t, w.WriteBody([]byte("hi")))
+	assert.Contains(t, buf.String(), "Connection: keep-alive\r\n")
+	assert.True(t, w.KeepAlive())
+
+	// Test: HTTP/1.1 keeps chunked coding
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	w.SetClientVersion("1.1")
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(chunkedHeaders()))
+	_, err = w.WriteChunkedBody([]byte("hello"))
+	require.NoError(t, err)
+	assert.Contains(t, buf.String(), "Transfer-Encoding: chunked\r\n")
+	assert.Contains(t, buf.String(), "\r\n\r\n5\r\nhello\r\n")
+	assert.NotContains(t, buf.String(), "Connection:")
+	assert.False(t, w.KeepAlive())
+	_, err = w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	require.NoError(t, w.WriteTrailers(headers.NewHeaders()))
+	assert.True(t, w.KeepAlive())
+}
+
+func TestWriterHeaderOrder(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+	h := headers.NewHeaders()
+	h.Add("content-length", "0")
+	h.Add("set-cookie", "a=1")
+	h.Add("x-b", "2")
+	h.Add("Set-Cookie", "b=2")
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(h))
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n"+
+		"Content-Length: 0\r\n"+
+		"Set-Cookie: a=1\r\n"+
+		"X-B: 2\r\n"+
+		"Set-Cookie: b=2\r\n"+
+		"\r\n", buf.String())
+}
+
+func TestWriterHeaderInjection(t *testing.T) {
+	// Test: CR/LF in a value is refused before anything is written
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	written := buf.Len()
+	h := GetDefaultHeaders(0)
+	h.Set("Location", "/next\r\nSet-Cookie: admin=1")
+	require.Error(t, w.WriteHeaders(h))
+	assert.Equal(t, written, buf.Len())
+
+	// Test: Invalid names are refused too
+	h = GetDefaultHeaders(0)
+	h.Set("Bad Name", "x")
+	require.Error(t, w.WriteHeaders(h))
+	assert.Equal(t, written, buf.Len())
+
+	// Test: Trailers are checked the same way
+	h = chunkedHeaders()
+	require.NoError(t, w.WriteHeaders(h))
+	_, err := w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	written = buf.Len()
+	trailers := headers.NewHeaders()
+	trailers.Set("X-Checksum", "abc\nX-Injected: 1")
+	require.Error(t, w.WriteTrailers(trailers))
+	assert.Equal(t, written, buf.Len())
+}
+
+func TestWriterOrder(t *testing.T) {
+	// Test: Body first gets an implicit status line and headers
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+	assert.Equal(t, StatusOK, w.StatusCode())
+	require.NoError(t, w.WriteBody([]byte("hi")))
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n"+
+		"Content-Length: 2\r\n"+
+		"Content-Type: text/html\r\n"+
+		"\r\n"+
+		"hi", buf.String())
+	assert.Equal(t, StatusOK, w.StatusCode())
+	assert.True(t, w.KeepAlive())
+
+	// Test: The body can't outgrow its Content-Length
+	require.Error(t, w.WriteBody([]byte("!")))
+	assert.Equal(t, 2, w.BytesWritten())
+
+	// Test: Status line and headers only once
+	require.ErrorIs(t, w.WriteStatusLine(StatusNotFound), ErrWriteOrder)
+	require.ErrorIs(t, w.WriteHeaders(GetDefaultHeaders(0)), ErrWriteOrder)
+
+	// Test: Headers before the status line
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	require.ErrorIs(t, w.WriteHeaders(GetDefaultHeaders(0)), ErrWriteOrder)
+	assert.Equal(t, 0, buf.Len())
+
+	// Test: A short body leaves the connection unusable
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(5)))
+	require.NoError(t, w.WriteBody([]byte("hi")))
+	assert.False(t, w.KeepAlive())
+
+	// Test: Chunks first get implicit chunked headers
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	_, err := w.WriteChunkedBody([]byte("hello"))
+	require.NoError(t, err)
+	assert.Contains(t, buf.String(), "HTTP/1.1 200 OK\r\n")
+	assert.Contains(t, buf.String(), "Transfer-Encoding: chunked\r\n")
+	assert.NotContains(t, buf.String(), "Content-Length")
+
+	// Test: Framing can't be mixed
+	require.ErrorIs(t, w.WriteBody([]byte("raw")), ErrWriteOrder)
+	require.ErrorIs(t, w.WriteTrailers(headers.NewHeaders()), ErrWriteOrder)
+	_, err = w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	_, err = w.WriteChunkedBody([]byte("late"))
+	require.ErrorIs(t, err, ErrWriteOrder)
+	require.NoError(t, w.WriteTrailers(headers.NewHeaders()))
+	assert.True(t, strings.HasSuffix(buf.String(), "5\r\nhello\r\n0\r\n\r\n"))
+	require.ErrorIs(t, w.WriteTrailers(headers.NewHeaders()), ErrWriteOrder)
+
+	// Test: Last chunk on a Content-Length response
+	w = NewWriter(&bytes.Buffer{})
+	require.NoError(t, w.WriteBody([]byte("hi")))
+	_, err = w.WriteChunkedBodyDone()
+	require.ErrorIs(t, err, ErrWriteOrder)
+}
+
+func TestWriterStatusLine(t *testing.T) {
+	// Test: Registered codes get their reason phrase
+	assert.Equal(t, "Too Many Requests", StatusText(StatusTooManyRequests))
+	assert.Equal(t, "Content Too Large", StatusText(StatusRequestEntityTooLarge))
+	assert.Equal(t, "", StatusText(299))
+	assert.Equal(t, "HTTP/1.1 308 Permanent Redirect\r\n", string(GetStatusLine(StatusPermanentRedirect)))
+	assert.Equal(t, "HTTP/1.1 299 \r\n", string(GetStatusLine(299)))
+
+	// Test: Custom reason phrase
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+	require.NoError(t, w.WriteStatusLineReason(599, "Network Connect Timeout"))
+	assert.Equal(t, "HTTP/1.1 599 Network Connect Timeout\r\n", buf.String())
+	assert.Equal(t, StatusCode(599), w.StatusCode())
+
+	// Test: Invalid codes and phrases
+	w = NewWriter(&bytes.Buffer{})
+	require.Error(t, w.WriteStatusLine(42))
+	require.Error(t, w.WriteStatusLineReason(200, "OK\r\nSet-Cookie: a=1"))
+
+	// Test: No body after 204, and no framing headers
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	require.NoError(t, w.WriteStatusLine(StatusNoContent))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(0)))
+	assert.NotContains(t, buf.String(), "Content-Length")
+	assert.NotContains(t, buf.String(), "Connection")
+	assert.True(t, w.KeepAlive())
+	require.Error(t, w.WriteBody([]byte("x")))
+
+	// Test: 304 keeps its Content-Length but has no body
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	require.NoError(t, w.WriteStatusLine(StatusNotModified))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(10)))
+	assert.Contains(t, buf.String(), "Content-Length: 10\r\n")
+	assert.True(t, w.KeepAlive())
+
+	// Test: An interim response is followed by the final one
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	require.NoError(t, w.WriteStatusLine(StatusContinue))
+	require.NoError(t, w.WriteHeaders(headers.NewHeaders()))
+	assert.False(t, w.KeepAlive())
+	require.NoError(t, w.WriteBody([]byte("hi")))
+	assert.True(t, strings.HasPrefix(buf.String(), "HTTP/1.1 100 Continue\r\n\r\nHTTP/1.1 200 OK\r\n"))
+	assert.True(t, w.KeepAlive())
+}
+
+func TestWriterBuffered(t *testing.T) {
+	// Test: A short body goes out with a Content-Length
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+	w.Header().Set("Content-Type", "text/plain")
+	_, err := io.WriteString(w, "hello ")
+	require.NoError(t, err)
+	_, err = io.WriteString(w, "world")
+	require.NoError(t, err)
+	assert.Equal(t, 0, buf.Len())
+	assert.Equal(t, StatusOK, w.StatusCode())
+	assert.Equal(t, 11, w.BytesWritten())
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n"+
+		"Content-Type: text/plain\r\n"+
+		"Content-Length: 11\r\n"+
+		"\r\n"+
+		"hello world", buf.String())
+	assert.True(t, w.KeepAlive())
+
+	// Test: Nothing written is an empty 200
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n", buf.String())
+	assert.True(t, w.KeepAlive())
+
+	// Test: An explicit status line is kept
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	require.NoError(t, w.WriteStatusLine(StatusNotFound))
+	_, err = w.Write([]byte("gone"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	assert.True(t, strings.HasPrefix(buf.String(), "HTTP/1.1 404 Not Found\r\n"))
+	assert.Contains(t, buf.String(), "Content-Length: 4\r\n")
+	assert.True(t, strings.HasSuffix(buf.String(), "\r\n\r\ngone"))
+
+	// Test: A long body switches to chunked coding
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	body := strings.Repeat("x", bufferSize+1)
+	_, err = io.WriteString(w, body)
+	require.NoError(t, err)
+	_, err = io.WriteString(w, "tail")
+	require.NoError(t, err)
+	assert.False(t, w.KeepAlive())
+	require.NoError(t, w.Finish())
+	res := buf.String()
+	assert.Contains(t, res, "Transfer-Encoding: chunked\r\n")
+	assert.NotContains(t, res, "Content-Length")
+	assert.True(t, strings.HasSuffix(res, fmt.Sprintf("%x\r\n%s\r\n4\r\ntail\r\n0\r\n\r\n", len(body), body)))
+	assert.True(t, w.KeepAlive())
+
+	// Test: HTTP/1.0 gets a body delimited by closing the connection
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	w.SetClientVersion("1.0")
+	_, err = io.WriteString(w, body)
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	res = buf.String()
+	assert.NotContains(t, res, "Transfer-Encoding")
+	assert.Contains(t, res, "Connection: close\r\n")
+	assert.True(t, strings.HasSuffix(res, "\r\n\r\n"+body))
+	assert.False(t, w.KeepAlive())
+
+	// Test: Write follows explicit headers
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(chunkedHeaders()))
+	_, err = w.Write([]byte("hi"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	assert.True(t, strings.HasSuffix(buf.String(), "\r\n\r\n2\r\nhi\r\n0\r\n\r\n"))
+
+	// Test: Buffered bodies can't be mixed with explicit headers
+	w = NewWriter(&bytes.Buffer{})
+	_, err = w.Write([]byte("hi"))
+	require.NoError(t, err)
+	require.NoError(t, w.WriteStatusLine(StatusCreated))
+	require.ErrorIs(t, w.WriteHeaders(GetDefaultHeaders(2)), ErrWriteOrder)
+
+	// Test: Reset drops a buffered response, but not a sent one
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	w.Header().Set("X-Partial", "1")
+	_, err = w.Write([]byte("half a page"))
+	require.NoError(t, err)
+	require.NoError(t, w.Reset())
+	require.NoError(t, w.WriteStatusLine(StatusInternalServerError))
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 500 Internal Server Error\r\nContent-Length: 0\r\n\r\n", buf.String())
+	require.ErrorIs(t, w.Reset(), ErrWriteOrder)
+
+	// Test: A buffered body is dropped if the status turns out to allow
+	// none
+	for _, code := range []StatusCode{StatusNoContent, StatusNotModified} {
+		buf = &bytes.Buffer{}
+		w = NewWriter(buf)
+		_, err = w.Write([]byte("abc"))
+		require.NoError(t, err)
+		require.NoError(t, w.WriteStatusLine(code))
+		require.NoError(t, w.Finish())
+		assert.Equal(t, string(GetStatusLine(code))+"\r\n", buf.String())
+		assert.True(t, w.KeepAlive())
+	}
+}
+
+func TestWriterTrailers(t *testing.T) {
+	// Test: Declared trailers are sent when the body ends
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+	require.NoError(t, w.DeclareTrailer("x-checksum", "X-Length"))
+	_, err := w.Write([]byte("hello"))
+	require.NoError(t, err)
+	require.NoError(t, w.SetTrailer("X-Checksum", "abc"))
+	require.NoError(t, w.SetTrailer("x-length", "5"))
+	require.NoError(t, w.Finish())
+	res := buf.String()
+	assert.Contains(t, res, "Transfer-Encoding: chunked\r\n")
+	assert.Contains(t, res, "Trailer: X-Checksum, X-Length\r\n")
+	assert.NotContains(t, res, "Content-Length")
+	assert.True(t, strings.HasSuffix(res, "\r\n\r\n5\r\nhello\r\n0\r\nX-Checksum: abc\r\nX-Length: 5\r\n\r\n"))
+	assert.True(t, w.KeepAlive())
+
+	// Test: No body still ends with the trailers
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	require.NoError(t, w.DeclareTrailer("X-Checksum"))
+	require.NoError(t, w.SetTrailer("X-Checksum", "none"))
+	require.NoError(t, w.Finish())
+	assert.True(t, strings.HasSuffix(buf.String(), "\r\n\r\n0\r\nX-Checksum: none\r\n\r\n"))
+
+	// Test: Forbidden and undeclared trailers
+	w = NewWriter(&bytes.Buffer{})
+	for _, name := range []string{"Content-Length", "host", "Transfer-Encoding", "Trailer", "bad name"} {
+		require.Error(t, w.DeclareTrailer(name), name)
+	}
+	require.Error(t, w.SetTrailer("X-Checksum", "abc"))
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	h := chunkedHeaders()
+	h.Set("Trailer", "X-Checksum, Content-Type")
+	require.Error(t, w.WriteHeaders(h))
+
+	// Test: Trailers written explicitly have to be declared
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(chunkedHeaders()))
+	_, err = w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	trailers := headers.NewHeaders()
+	trailers.Set("X-Other", "1")
+	require.Error(t, w.WriteTrailers(trailers))
+	trailers = headers.NewHeaders()
+	trailers.Set("X-Checksum", "abc")
+	require.NoError(t, w.WriteTrailers(trailers))
+	require.ErrorIs(t, w.SetTrailer("X-Checksum", "late"), ErrWriteOrder)
+
+	// Test: No trailers on a response that is not chunked
+	w = NewWriter(&bytes.Buffer{})
+	require.NoError(t, w.DeclareTrailer("X-Checksum"))
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.Error(t, w.WriteHeaders(GetDefaultHeaders(2)))
+	w = NewWriter(&bytes.Buffer{})
+	require.NoError(t, w.WriteBody([]byte("hi")))
+	require.ErrorIs(t, w.DeclareTrailer("X-Checksum"), ErrWriteOrder)
+}
+
+func TestWriterHijack(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+	hijacker := func() (net.Conn, error) { return server, nil }
+
+	// Test: Without a hijacker there is nothing to take over
+	w := NewWriter(&bytes.Buffer{})
+	_, err := w.Hijack()
+	require.ErrorIs(t, err, ErrNotHijackable)
+
+	// Test: Not in the middle of a response
+	w = NewWriter(&bytes.Buffer{})
+	w.SetHijacker(hijacker)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	_, err = w.Hijack()
+	require.ErrorIs(t, err, ErrWriteOrder)
+	w = NewWriter(&bytes.Buffer{})
+	w.SetHijacker(hijacker)
+	_, err = w.Write([]byte("buffered"))
+	require.NoError(t, err)
+	_, err = w.Hijack()
+	require.ErrorIs(t, err, ErrWriteOrder)
+
+	// Test: After a 101, and the writer is done for
+	buf := &bytes.Buffer{}
+	w = NewWriter(buf)
+	w.SetHijacker(hijacker)
+	require.NoError(t, w.WriteStatusLine(StatusSwitchingProtocols))
+	h := headers.NewHeaders()
+	h.Set("Upgrade", "chat")
+	h.Set("Connection", "Upgrade")
+	require.NoError(t, w.WriteHeaders(h))
+	conn, err := w.Hijack()
+	require.NoError(t, err)
+	assert.Equal(t, server, conn)
+	assert.False(t, w.KeepAlive())
+	require.NoError(t, w.Finish())
+	_, err = w.Write([]byte("late"))
+	require.ErrorIs(t, err, ErrHijacked)
+	require.ErrorIs(t, w.WriteStatusLine(StatusOK), ErrHijacked)
+	require.ErrorIs(t, w.Reset(), ErrHijacked)
+	_, err = w.Hijack()
+	require.ErrorIs(t, err, ErrHijacked)
+	assert.Equal(t, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: chat\r\nConnection: Upgrade\r\n\r\n", buf.String())
+	assert.Equal(t, StatusSwitchingProtocols, w.StatusCode())
+
+	// Test: Hijacked before any response, there is no status
+	w = NewWriter(&bytes.Buffer{})
+	w.SetHijacker(hijacker)
+	_, err = w.Hijack()
+	require.NoError(t, err)
+	assert.Equal(t, StatusCode(0), w.StatusCode())
+}
+
+func TestWriterHead(t *testing.T) {
+	// Test: A buffered body only counts towards the Content-Length
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+	w.SetRequestMethod("HEAD")
+	_, err := w.Write([]byte("hello"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n"+
+		"Content-Length: 5\r\n"+
+		"Content-Type: text/html\r\n"+
+		"\r\n", buf.String())
+	assert.True(t, w.KeepAlive())
+
+	// Test: Explicit headers, with or without the body written
+	for _, body := range []string{"hello", ""} {
+		buf = &bytes.Buffer{}
+		w = NewWriter(buf)
+		w.SetRequestMethod("HEAD")
+		require.NoError(t, w.WriteStatusLine(StatusOK))
+		require.NoError(t, w.WriteHeaders(GetDefaultHeaders(5)))
+		require.NoError(t, w.WriteBody([]byte(body)))
+		require.NoError(t, w.Finish())
+		assert.Contains(t, buf.String(), "Content-Length: 5\r\n")
+		assert.True(t, strings.HasSuffix(buf.String(), "\r\n\r\n"))
+		assert.NotContains(t, buf.String(), "hello")
+		assert.True(t, w.KeepAlive())
+	}
+
+	// Test: A chunked body sends no chunks, not even the last one
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	w.SetRequestMethod("HEAD")
+	_, err = w.WriteChunkedBody([]byte("hello"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	assert.Contains(t, buf.String(), "Transfer-Encoding: chunked\r\n")
+	assert.True(t, strings.HasSuffix(buf.String(), "\r\n\r\n"))
+	assert.NotContains(t, buf.String(), "hello")
+	assert.NotContains(t, buf.String(), "0\r\n\r\n")
+	assert.True(t, w.KeepAlive())
+}
+
+func TestErrorPage(t *testing.T) {
+	// Test: The message is escaped
+	page := string(ErrorPage(StatusBadRequest, "bad <script>"))
+	assert.Equal(t, "<html>\n<head>\n<title>400 Bad Request</title>\n</head>\n<body>\n<h1>Bad Request</h1>\n<p>bad &lt;script&gt;</p>\n</body>\n</html>\n", page)
+
+	// Test: Without a message there is no paragraph
+	page = string(ErrorPage(StatusNotFound, ""))
+	assert.Equal(t, "<html>\n<head>\n<title>404 Not Found</title>\n</head>\n<body>\n<h1>Not Found</h1>\n</body>\n</html>\n", page)
+}
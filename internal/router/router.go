@@ -0,0 +1,260 @@
+package router
+
+import (
+	"fmt"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+	"slices"
+	"strings"
+)
+
+type segmentKind int
+
+const (
+	segmentLiteral segmentKind = iota
+	segmentWildcard
+	segmentRest
+)
+
+type segment struct {
+	kind segmentKind
+	// value is the literal text or the wildcard name
+	value string
+}
+
+type route struct {
+	pattern  string
+	method   string
+	segments []segment
+	handler  server.Handler
+}
+
+// Router dispatches requests to handlers registered by method and path
+// pattern. Its Serve method is a server.Handler.
+type Router struct {
+	routes []*route
+	// NotFound is called when no pattern matches the path.
+	// It defaults to a plain 404 page.
+	NotFound server.Handler
+}
+
+func New() *Router {
+	return &Router{}
+}
+
+// Handle registers handler for pattern. A pattern is an optional method
+// followed by a path, e.g. "GET /users/{id}" or "/static/{path...}".
+// {name} matches exactly one non-empty path segment, {name...} matches the
+// rest of the path and has to come last. Wildcard values are available
+// through request.Request.PathValue. Without a method the pattern matches
+// every method. A GET pattern matches HEAD too, unless there is a HEAD
+// pattern for the same path. Any middleware given wraps only this route, the first one
+// being the outermost. Handle panics on invalid or duplicate patterns.
+func (rt *Router) Handle(pattern string, handler server.Handler, middleware ...server.Middleware) {
+	r, err := parsePattern(pattern)
+	if err != nil {
+		panic(fmt.Sprintf("router: %v", err))
+	}
+	for _, existing := range rt.routes {
+		if existing.method == r.method && existing.samePath(r) {
+			panic(fmt.Sprintf("router: pattern %q conflicts with %q", pattern, existing.pattern))
+		}
+	}
+	r.handler = server.Chain(middleware...)(handler)
+	rt.routes = append(rt.routes, r)
+}
+
+// Serve dispatches the request to the most specific matching route. If the
+// path matches but the method does not, it answers 405 with an Allow header.
+func (rt *Router) Serve(w *response.Writer, req *request.Request) {
+	path := req.URL.RawPath
+
+	var best *route
+	var bestValues map[string]string
+	allowed := []string{}
+	for _, r := range rt.routes {
+		values, ok := r.match(path)
+		if !ok {
+			continue
+		}
+		if r.methodRank(req.RequestLine.Method) == 0 {
+			for _, method := range r.methods() {
+				if !slices.Contains(allowed, method) {
+					allowed = append(allowed, method)
+				}
+			}
+			continue
+		}
+		if best == nil || r.moreSpecific(best, req.RequestLine.Method) {
+			best = r
+			bestValues = values
+		}
+	}
+
+	if best != nil {
+		for name, value := range bestValues {
+			req.SetPathValue(name, value)
+		}
+		best.handler(w, req)
+		return
+	}
+	if len(allowed) > 0 {
+		slices.Sort(allowed)
+		methodNotAllowed(w, allowed)
+		return
+	}
+	if rt.NotFound != nil {
+		rt.NotFound(w, req)
+		return
+	}
+	notFound(w, req)
+}
+
+func parsePattern(pattern string) (*route, error) {
+	r := &route{pattern: pattern}
+	path := pattern
+	if method, rest, found := strings.Cut(pattern, " "); found {
+		r.method = method
+		path = strings.TrimLeft(rest, " ")
+		for _, c := range method {
+			if c < 'A' || c > 'Z' {
+				return nil, fmt.Errorf("invalid method in pattern %q", pattern)
+			}
+		}
+	}
+	if !strings.HasPrefix(path, "/") {
+		return nil, fmt.Errorf("pattern %q does not start with a path", pattern)
+	}
+
+	names := []string{}
+	parts := strings.Split(path[1:], "/")
+	for i, part := range parts {
+		if !strings.HasPrefix(part, "{") || !strings.HasSuffix(part, "}") {
+			if strings.ContainsAny(part, "{}") {
+				return nil, fmt.Errorf("bad wildcard segment %q in pattern %q", part, pattern)
+			}
+			r.segments = append(r.segments, segment{kind: segmentLiteral, value: part})
+			continue
+		}
+
+		name := part[1 : len(part)-1]
+		kind := segmentWildcard
+		if strings.HasSuffix(name, "...") {
+			if i != len(parts)-1 {
+				return nil, fmt.Errorf("%q is not the last segment in pattern %q", part, pattern)
+			}
+			name = strings.TrimSuffix(name, "...")
+			kind = segmentRest
+		}
+		if name == "" || slices.Contains(names, name) {
+			return nil, fmt.Errorf("bad wildcard name %q in pattern %q", part, pattern)
+		}
+		names = append(names, name)
+		r.segments = append(r.segments, segment{kind: kind, value: name})
+	}
+	return r, nil
+}
+
+// match matches the escaped request path segment by segment, so that an
+// encoded "/" (%2F) stays inside its segment. Wildcard values are decoded.
+func (r *route) match(rawPath string) (map[string]string, bool) {
+	if !strings.HasPrefix(rawPath, "/") {
+		return nil, false
+	}
+	parts := strings.Split(rawPath[1:], "/")
+	values := map[string]string{}
+	for i, seg := range r.segments {
+		if i >= len(parts) {
+			return nil, false
+		}
+		part, err := request.Unescape(parts[i])
+		if err != nil {
+			return nil, false
+		}
+		switch seg.kind {
+		case segmentLiteral:
+			if part != seg.value {
+				return nil, false
+			}
+		case segmentWildcard:
+			if part == "" {
+				return nil, false
+			}
+			values[seg.value] = part
+		case segmentRest:
+			rest, err := request.Unescape(strings.Join(parts[i:], "/"))
+			if err != nil {
+				return nil, false
+			}
+			values[seg.value] = rest
+			return values, true
+		}
+	}
+	if len(parts) != len(r.segments) {
+		return nil, false
+	}
+	return values, true
+}
+
+// samePath reports whether both routes match exactly the same paths.
+func (r *route) samePath(other *route) bool {
+	return slices.EqualFunc(r.segments, other.segments, func(a, b segment) bool {
+		return a.kind == b.kind && (a.kind != segmentLiteral || a.value == b.value)
+	})
+}
+
+// moreSpecific reports whether r should win over other when both match:
+// literal segments beat wildcards, which beat a trailing {name...}, and a
+// route for the request's method beats a GET route serving a HEAD, which
+// beats one without a method.
+func (r *route) moreSpecific(other *route, method string) bool {
+	for i := 0; i < len(r.segments) && i < len(other.segments); i++ {
+		if r.segments[i].kind != other.segments[i].kind {
+			return r.segments[i].kind < other.segments[i].kind
+		}
+	}
+	if len(r.segments) != len(other.segments) {
+		return len(r.segments) > len(other.segments)
+	}
+	return r.methodRank(method) > other.methodRank(method)
+}
+
+// methodRank says how well the route's method fits a request's method: 0
+// for not at all, then better from 1 to 3.
+func (r *route) methodRank(method string) int {
+	switch {
+	case r.method == method:
+		return 3
+	case r.method == "GET" && method == "HEAD":
+		return 2
+	case r.method == "":
+		return 1
+	default:
+		return 0
+	}
+}
+
+// methods returns the methods the route serves, for the Allow header.
+func (r *route) methods() []string {
+	if r.method == "GET" {
+		return []string{"GET", "HEAD"}
+	}
+	return []string{r.method}
+}
+
+func notFound(w *response.Writer, _ *request.Request) {
+	body := response.ErrorPage(response.StatusNotFound, "")
+	w.WriteStatusLine(response.StatusNotFound)
+	w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+	w.WriteBody(body)
+}
+
+func methodNotAllowed(w *response.Writer, allowed []string) {
+	body := response.ErrorPage(response.StatusMethodNotAllowed, "")
+	w.WriteStatusLine(response.StatusMethodNotAllowed)
+	h := response.GetDefaultHeaders(len(body))
+	h.Set("Allow", strings.Join(allowed, ", "))
+	w.WriteHeaders(h)
+	w.WriteBody(body)
+}
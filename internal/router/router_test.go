@@ -0,0 +1,171 @@
+package router
+
+import (
+	"bytes"
+	"fmt"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// serve runs a request through the router and returns the raw response
+func serve(t *testing.T, rt *Router, method, target string) string {
+	t.Helper()
+	req, err := request.RequestFromReader(strings.NewReader(method + " " + target + " HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	buf := &bytes.Buffer{}
+	w := response.NewWriter(buf)
+	w.SetRequestMethod(method)
+	rt.Serve(w, req)
+	return buf.String()
+}
+
+func named(name string) func(w *response.Writer, req *request.Request) {
+	return func(w *response.Writer, req *request.Request) {
+		body := []byte(name + " id=" + req.PathValue("id") + " path=" + req.PathValue("path"))
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody(body)
+	}
+}
+
+func TestRouterServe(t *testing.T) {
+	rt := New()
+	rt.Handle("GET /users/{id}", named("get-user"))
+	rt.Handle("DELETE /users/{id}", named("delete-user"))
+	rt.Handle("GET /users/me", named("me"))
+	rt.Handle("/static/{path...}", named("static"))
+	rt.Handle("/", named("root"))
+
+	// Test: Wildcard segment
+	res := serve(t, rt, "GET", "/users/42")
+	assert.True(t, strings.HasPrefix(res, "HTTP/1.1 200 OK\r\n"))
+	assert.True(t, strings.HasSuffix(res, "get-user id=42 path="))
+
+	// Test: Method selects the route
+	res = serve(t, rt, "DELETE", "/users/42?force=1")
+	assert.True(t, strings.HasSuffix(res, "delete-user id=42 path="))
+
+	// Test: Literal segment wins over wildcard
+	res = serve(t, rt, "GET", "/users/me")
+	assert.True(t, strings.HasSuffix(res, "me id= path="))
+
+	// Test: Rest wildcard
+	res = serve(t, rt, "POST", "/static/css/site.css")
+	assert.True(t, strings.HasSuffix(res, "static id= path=css/site.css"))
+	res = serve(t, rt, "GET", "/static/")
+	assert.True(t, strings.HasSuffix(res, "static id= path="))
+
+	// Test: Exact root
+	res = serve(t, rt, "GET", "/")
+	assert.True(t, strings.HasSuffix(res, "root id= path="))
+
+	// Test: Wrong method
+	res = serve(t, rt, "POST", "/users/42")
+	assert.True(t, strings.HasPrefix(res, "HTTP/1.1 405 Method Not Allowed\r\n"))
+	assert.Contains(t, res, "Allow: DELETE, GET, HEAD\r\n")
+
+	// Test: A GET route serves HEAD, and a HEAD route wins over it
+	res = serve(t, rt, "HEAD", "/users/42")
+	assert.True(t, strings.HasPrefix(res, "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, res, "Content-Length: 20\r\n")
+	assert.True(t, strings.HasSuffix(res, "\r\n\r\n"))
+	rt.Handle("HEAD /users/{id}", named("head-user"))
+	res = serve(t, rt, "HEAD", "/users/42")
+	assert.Contains(t, res, "Content-Length: 21\r\n")
+	res = serve(t, rt, "PUT", "/users/42")
+	assert.Contains(t, res, "Allow: DELETE, GET, HEAD\r\n")
+
+	// Test: Unknown path
+	res = serve(t, rt, "GET", "/nope")
+	assert.True(t, strings.HasPrefix(res, "HTTP/1.1 404 Not Found\r\n"))
+	res = serve(t, rt, "GET", "/users/")
+	assert.True(t, strings.HasPrefix(res, "HTTP/1.1 404 Not Found\r\n"))
+	res = serve(t, rt, "GET", "/static")
+	assert.True(t, strings.HasPrefix(res, "HTTP/1.1 404 Not Found\r\n"))
+
+	// Test: Custom not found handler
+	rt.NotFound = named("custom")
+	res = serve(t, rt, "GET", "/nope")
+	assert.True(t, strings.HasSuffix(res, "custom id= path="))
+}
+
+func TestRouterHandlePanics(t *testing.T) {
+	rt := New()
+	rt.Handle("GET /users/{id}", named("a"))
+
+	assert.Panics(t, func() { rt.Handle("GET /users/{name}", named("b")) })
+	assert.Panics(t, func() { rt.Handle("users", named("b")) })
+	assert.Panics(t, func() { rt.Handle("/a/{path...}/b", named("b")) })
+	assert.Panics(t, func() { rt.Handle("/a/{id}/{id}", named("b")) })
+	assert.Panics(t, func() { rt.Handle("get /a", named("b")) })
+	assert.NotPanics(t, func() { rt.Handle("POST /users/{id}", named("b")) })
+}
+
+func TestRouterMiddleware(t *testing.T) {
+	calls := []string{}
+	trace := func(name string) server.Middleware {
+		return func(next server.Handler) server.Handler {
+			return func(w *response.Writer, req *request.Request) {
+				calls = append(calls, name+" before")
+				next(w, req)
+				calls = append(calls, fmt.Sprintf("%s after %d %d", name, w.StatusCode(), w.BytesWritten()))
+			}
+		}
+	}
+
+	rt := New()
+	rt.Handle("GET /users/{id}", named("get-user"), trace("outer"), trace("inner"))
+	rt.Handle("GET /plain", named("plain"))
+
+	// Test: Route middleware runs in order and sees the response
+	serve(t, rt, "GET", "/users/7")
+	assert.Equal(t, []string{
+		"outer before",
+		"inner before",
+		"inner after 200 19",
+		"outer after 200 19",
+	}, calls)
+
+	// Test: Middleware is per route
+	calls = []string{}
+	serve(t, rt, "GET", "/plain")
+	assert.Empty(t, calls)
+
+	// Test: Chain of router wide middleware
+	calls = []string{}
+	handler := server.Chain(trace("first"), trace("second"))(rt.Serve)
+	req, err := request.RequestFromReader(strings.NewReader("GET /nope HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	handler(response.NewWriter(&bytes.Buffer{}), req)
+	assert.Equal(t, []string{
+		"first before",
+		"second before",
+		"second after 404 93",
+		"first after 404 93",
+	}, calls)
+}
+
+func TestRouterEscapedPath(t *testing.T) {
+	rt := New()
+	rt.Handle("GET /users/{id}", named("get-user"))
+	rt.Handle("GET /files/{path...}", named("files"))
+	rt.Handle("GET /a b", named("space"))
+
+	// Test: Encoded slash stays inside its segment
+	res := serve(t, rt, "GET", "/users/a%2Fb")
+	assert.True(t, strings.HasSuffix(res, "get-user id=a/b path="))
+
+	// Test: Rest wildcard is decoded
+	res = serve(t, rt, "GET", "/files/dir/na%20me.txt")
+	assert.True(t, strings.HasSuffix(res, "files id= path=dir/na me.txt"))
+
+	// Test: Literal segments match the decoded path
+	res = serve(t, rt, "GET", "/a%20b")
+	assert.True(t, strings.HasSuffix(res, "space id= path="))
+}
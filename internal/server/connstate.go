@@ -0,0 +1,95 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net"
+	"time"
+)
+
+// State is a stage in the life of a connection, reported to
+// Server.ConnState.
+type State int
+
+const (
+	// StateNew is a connection that was just accepted. It turns active
+	// once the first byte of a request arrives.
+	StateNew State = iota
+	// StateActive is a connection that is reading a request, running the
+	// handler or writing the response.
+	StateActive
+	// StateIdle is a keep-alive connection waiting for the next request.
+	StateIdle
+	// StateHijacked is a connection taken over with response.Writer.Hijack.
+	// It is final, the server doesn't report the close.
+	StateHijacked
+	// StateClosed is a connection the server closed. It is final.
+	StateClosed
+)
+
+func (s State) String() string {
+	switch s {
+	case StateNew:
+		return "new"
+	case StateActive:
+		return "active"
+	case StateIdle:
+		return "idle"
+	case StateHijacked:
+		return "hijacked"
+	case StateClosed:
+		return "closed"
+	default:
+		return "unknown"
+	}
+}
+
+// ConnInfo describes the connection a request came in on.
+type ConnInfo struct {
+	// ID numbers the server's connections from 1, requests with the same
+	// ID shared a connection.
+	ID         uint64
+	RemoteAddr net.Addr
+	LocalAddr  net.Addr
+}
+
+type connInfoKey struct{}
+
+// ConnInfoFromContext returns the connection details the server puts in
+// every request's context, see request.Request.Context.
+func ConnInfoFromContext(ctx context.Context) (ConnInfo, bool) {
+	info, ok := ctx.Value(connInfoKey{}).(ConnInfo)
+	return info, ok
+}
+
+// setState records a connection's new state and reports it. It returns
+// false if the connection was closed by a shutdown meanwhile.
+func (s *Server) setState(conn net.Conn, state State) bool {
+	s.mu.Lock()
+	if _, ok := s.conns[conn]; !ok {
+		s.mu.Unlock()
+		return false
+	}
+	s.conns[conn] = connStatus{state: state, since: time.Now()}
+	s.mu.Unlock()
+	s.connState(conn, state)
+	return true
+}
+
+// connState calls the ConnState hook, if any.
+func (s *Server) connState(conn net.Conn, state State) {
+	if s.ConnState != nil {
+		s.ConnState(conn, state)
+	}
+}
+
+// hijackedConn is a hijacked connection that returns what the request
+// reader had buffered before reading on.
+type hijackedConn struct {
+	net.Conn
+	reader io.Reader
+}
+
+func (c *hijackedConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
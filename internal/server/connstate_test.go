@@ -0,0 +1,155 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// stateRecorder collects the states reported for each connection.
+type stateRecorder struct {
+	mu     sync.Mutex
+	states map[net.Conn][]State
+}
+
+func (r *stateRecorder) record(conn net.Conn, state State) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.states == nil {
+		r.states = map[net.Conn][]State{}
+	}
+	r.states[conn] = append(r.states[conn], state)
+}
+
+// only returns the states of the only connection so far.
+func (r *stateRecorder) only() []State {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.states) != 1 {
+		return nil
+	}
+	for _, states := range r.states {
+		return append([]State(nil), states...)
+	}
+	return nil
+}
+
+// connInfo answers with the ID and remote address of the connection.
+func connInfo(w *response.Writer, req *request.Request) {
+	info, ok := ConnInfoFromContext(req.Context())
+	if !ok {
+		w.WriteStatusLine(response.StatusInternalServerError)
+		return
+	}
+	fmt.Fprintf(w, "%d %s", info.ID, info.RemoteAddr)
+}
+
+// readResponse reads one response with a Content-Length body off r.
+func readResponse(t *testing.T, r *bufio.Reader) string {
+	t.Helper()
+	statusLine, err := r.ReadString('\n')
+	require.NoError(t, err)
+	length := 0
+	for {
+		line, err := r.ReadString('\n')
+		require.NoError(t, err)
+		if line == "\r\n" {
+			break
+		}
+		if value, ok := strings.CutPrefix(line, "Content-Length: "); ok {
+			fmt.Sscanf(value, "%d", &length)
+		}
+	}
+	body := make([]byte, length)
+	_, err = io.ReadFull(r, body)
+	require.NoError(t, err)
+	return strings.TrimSpace(statusLine) + " " + string(body)
+}
+
+func TestConnState(t *testing.T) {
+	// Test: A keep-alive connection goes back and forth between active
+	// and idle, and requests on it share the connection's context
+	states := &stateRecorder{}
+	s := New(connInfo)
+	s.ConnState = states.record
+	defer s.Close()
+	require.NoError(t, s.Listen(0))
+	conn, err := net.Dial("tcp", s.Addrs()[0].String())
+	require.NoError(t, err)
+	r := bufio.NewReader(conn)
+	_, err = io.WriteString(conn, "GET /1 HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	first := readResponse(t, r)
+	_, err = io.WriteString(conn, "GET /2 HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	assert.Equal(t, first, readResponse(t, r))
+	assert.Equal(t, fmt.Sprintf("HTTP/1.1 200 OK 1 %s", conn.LocalAddr()), first)
+	conn.Close()
+	want := []State{StateNew, StateActive, StateIdle, StateActive, StateIdle, StateClosed}
+	assert.Eventually(t, func() bool { return assert.ObjectsAreEqual(want, states.only()) }, time.Second, time.Millisecond)
+
+	// Test: Another connection gets another ID
+	conn, err = net.Dial("tcp", s.Addrs()[0].String())
+	require.NoError(t, err)
+	assert.True(t, strings.HasSuffix(get(t, conn, "/"), fmt.Sprintf("\r\n\r\n2 %s", conn.LocalAddr())))
+}
+
+// chat switches to a line based echo protocol.
+func chat(w *response.Writer, req *request.Request) {
+	w.WriteStatusLine(response.StatusSwitchingProtocols)
+	h := headers.NewHeaders()
+	h.Set("Upgrade", "chat")
+	h.Set("Connection", "Upgrade")
+	w.WriteHeaders(h)
+	conn, err := w.Hijack()
+	if err != nil {
+		panic(err)
+	}
+	go func() {
+		defer conn.Close()
+		lines := bufio.NewScanner(conn)
+		for lines.Scan() {
+			fmt.Fprintf(conn, "echo %s\n", lines.Text())
+		}
+	}()
+}
+
+func TestHijack(t *testing.T) {
+	// Test: The handler takes over the connection, including what the
+	// client sent right after the request, and the server lets go of it
+	states := &stateRecorder{}
+	s := New(chat)
+	s.ConnState = states.record
+	defer s.Close()
+	require.NoError(t, s.Listen(0))
+	conn, err := net.Dial("tcp", s.Addrs()[0].String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = io.WriteString(conn, "GET /chat HTTP/1.1\r\nHost: localhost\r\nConnection: Upgrade\r\nUpgrade: chat\r\n\r\nhi\n")
+	require.NoError(t, err)
+	r := bufio.NewReader(conn)
+	assert.Equal(t, "HTTP/1.1 101 Switching Protocols ", readResponse(t, r))
+	line, err := r.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "echo hi\n", line)
+
+	require.NoError(t, s.Close())
+	_, err = io.WriteString(conn, "still there?\n")
+	require.NoError(t, err)
+	line, err = r.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "echo still there?\n", line)
+	assert.Equal(t, []State{StateNew, StateActive, StateHijacked}, states.only())
+	assert.Equal(t, int64(0), s.ConnStats().Active)
+}
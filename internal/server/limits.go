@@ -0,0 +1,155 @@
+package server
+
+import (
+	"errors"
+	"httpfromtcp/internal/response"
+	"io"
+	"net"
+	"time"
+)
+
+// Accept errors, e.g. running out of file descriptors, are retried after
+// a delay that doubles up to maxAcceptBackoff.
+const (
+	minAcceptBackoff = 5 * time.Millisecond
+	maxAcceptBackoff = time.Second
+)
+
+// rejectTimeout bounds answering a connection over the limits.
+const rejectTimeout = time.Second
+
+// maxRejectDrain is how much of a rejected client's request is read before
+// hanging up.
+const maxRejectDrain = 64 << 10
+
+var (
+	errTooManyConns      = errors.New("too many connections")
+	errTooManyConnsPerIP = errors.New("too many connections from your address")
+)
+
+// ConnStats counts a server's connections.
+type ConnStats struct {
+	// Active is the number of open connections being served.
+	Active int64
+	// Accepted is the number of connections accepted so far, including
+	// the rejected ones.
+	Accepted int64
+	// Rejected is the number of connections turned away by MaxConns or
+	// MaxConnsPerIP.
+	Rejected int64
+}
+
+// ConnStats returns the current connection counters.
+func (s *Server) ConnStats() ConnStats {
+	return ConnStats{
+		Active:   s.active.Load(),
+		Accepted: s.accepted.Load(),
+		Rejected: s.rejected.Load(),
+	}
+}
+
+// connSlots returns the semaphore for MaxConns, nil if there is no limit.
+func (s *Server) connSlots() chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.slots == nil && s.MaxConns > 0 {
+		s.slots = make(chan struct{}, s.MaxConns)
+	}
+	return s.slots
+}
+
+// admission is what admit took for a connection, for release to give
+// back.
+type admission struct {
+	// slots is the MaxConns semaphore a slot was taken from, or nil
+	slots chan struct{}
+	// ip is the client address counted against MaxConnsPerIP, or ""
+	ip string
+}
+
+// admit decides whether an accepted connection is served. taken is the
+// semaphore a slot was already taken from while waiting to accept, if
+// any. It returns the reason if the connection is over a limit.
+func (s *Server) admit(conn net.Conn, taken chan struct{}) (admission, error) {
+	adm := admission{slots: taken}
+	if s.RejectOverLimit {
+		if slots := s.connSlots(); slots != nil {
+			select {
+			case slots <- struct{}{}:
+			default:
+				return admission{}, errTooManyConns
+			}
+			adm.slots = slots
+		}
+	}
+	if ip := clientIP(conn.RemoteAddr()); ip != "" && s.MaxConnsPerIP > 0 {
+		s.mu.Lock()
+		if s.connsPerIP[ip] >= s.MaxConnsPerIP {
+			s.mu.Unlock()
+			if adm.slots != nil {
+				<-adm.slots
+			}
+			return admission{}, errTooManyConnsPerIP
+		}
+		if s.connsPerIP == nil {
+			s.connsPerIP = map[string]int{}
+		}
+		s.connsPerIP[ip]++
+		s.mu.Unlock()
+		adm.ip = ip
+	}
+	s.active.Add(1)
+	return adm, nil
+}
+
+// release gives back what admit took for a connection once it is closed.
+func (s *Server) release(adm admission) {
+	s.active.Add(-1)
+	if adm.ip != "" {
+		s.mu.Lock()
+		if s.connsPerIP[adm.ip]--; s.connsPerIP[adm.ip] <= 0 {
+			delete(s.connsPerIP, adm.ip)
+		}
+		s.mu.Unlock()
+	}
+	if adm.slots != nil {
+		<-adm.slots
+	}
+}
+
+// reject answers a connection over the limits with 503 and closes it.
+func (s *Server) reject(conn net.Conn, err error) {
+	defer conn.Close()
+	s.rejected.Add(1)
+	conn.SetDeadline(time.Now().Add(rejectTimeout))
+	w := response.NewWriter(conn)
+	w.SetKeepAlive(false)
+	if !s.writeError(w, nil, response.StatusServiceUnavailable, err) {
+		abort(conn)
+		return
+	}
+	w.Finish()
+	// closing with the request still unread would reset the connection,
+	// and the client could lose the response
+	if c, ok := conn.(interface{ CloseWrite() error }); ok {
+		c.CloseWrite()
+	}
+	io.Copy(io.Discard, io.LimitReader(conn, maxRejectDrain))
+}
+
+// clientIP returns the IP address of a TCP client, or "" for other kinds
+// of connections, which are not limited per client.
+func clientIP(addr net.Addr) string {
+	if addr, ok := addr.(*net.TCPAddr); ok {
+		return addr.IP.String()
+	}
+	return ""
+}
+
+// nextBackoff returns how long to wait after another failed Accept.
+func nextBackoff(backoff time.Duration) time.Duration {
+	if backoff == 0 {
+		return minAcceptBackoff
+	}
+	return min(2*backoff, maxAcceptBackoff)
+}
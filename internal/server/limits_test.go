@@ -0,0 +1,137 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// dialIdle opens a connection to s that sends nothing and waits until the
+// server counts it as active.
+func dialIdle(t *testing.T, s *Server, active int64) net.Conn {
+	t.Helper()
+	conn, err := net.Dial("tcp", s.Addrs()[0].String())
+	require.NoError(t, err)
+	require.Eventually(t, func() bool { return s.ConnStats().Active == active }, time.Second, time.Millisecond)
+	return conn
+}
+
+func TestMaxConns(t *testing.T) {
+	// Test: Over MaxConns, the next connection waits until one closes
+	s := New(hello)
+	s.MaxConns = 1
+	defer s.Close()
+	require.NoError(t, s.Listen(0))
+	idle := dialIdle(t, s, 1)
+	conn, err := net.Dial("tcp", s.Addrs()[0].String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = io.WriteString(conn, "GET /waiting HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	require.NoError(t, err)
+	conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	_, err = conn.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, os.ErrDeadlineExceeded)
+	idle.Close()
+	conn.SetReadDeadline(time.Time{})
+	res, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.True(t, strings.HasSuffix(string(res), "hello /waiting"))
+	assert.Eventually(t, func() bool { return s.ConnStats() == ConnStats{Active: 0, Accepted: 2} }, time.Second, time.Millisecond)
+
+	// Test: With RejectOverLimit, it gets a 503 instead
+	s = New(hello)
+	s.MaxConns = 1
+	s.RejectOverLimit = true
+	defer s.Close()
+	require.NoError(t, s.Listen(0))
+	idle = dialIdle(t, s, 1)
+	conn, err = net.Dial("tcp", s.Addrs()[0].String())
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(get(t, conn, "/"), "HTTP/1.1 503 Service Unavailable\r\n"))
+	assert.Equal(t, ConnStats{Active: 1, Accepted: 2, Rejected: 1}, s.ConnStats())
+	idle.Close()
+	require.Eventually(t, func() bool { return s.ConnStats().Active == 0 }, time.Second, time.Millisecond)
+	conn, err = net.Dial("tcp", s.Addrs()[0].String())
+	require.NoError(t, err)
+	assert.True(t, strings.HasSuffix(get(t, conn, "/again"), "hello /again"))
+
+	// Test: MaxConnsPerIP rejects a client's extra connections
+	s = New(hello)
+	s.MaxConnsPerIP = 2
+	defer s.Close()
+	require.NoError(t, s.Listen(0))
+	idle = dialIdle(t, s, 1)
+	defer idle.Close()
+	idle2 := dialIdle(t, s, 2)
+	conn, err = net.Dial("tcp", s.Addrs()[0].String())
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(get(t, conn, "/"), "HTTP/1.1 503 Service Unavailable\r\n"))
+	idle2.Close()
+	require.Eventually(t, func() bool { return s.ConnStats().Active == 1 }, time.Second, time.Millisecond)
+	conn, err = net.Dial("tcp", s.Addrs()[0].String())
+	require.NoError(t, err)
+	assert.True(t, strings.HasSuffix(get(t, conn, "/again"), "hello /again"))
+	assert.Equal(t, int64(1), s.ConnStats().Rejected)
+
+	// Test: The 503 carries the body an ErrorHandler wrote with Write
+	s = New(hello)
+	s.MaxConns = 1
+	s.RejectOverLimit = true
+	s.ErrorHandler = writeErrorPage
+	defer s.Close()
+	require.NoError(t, s.Listen(0))
+	idle = dialIdle(t, s, 1)
+	defer idle.Close()
+	conn, err = net.Dial("tcp", s.Addrs()[0].String())
+	require.NoError(t, err)
+	page := get(t, conn, "/")
+	assert.True(t, strings.HasPrefix(page, "HTTP/1.1 503 Service Unavailable\r\n"))
+	assert.True(t, strings.HasSuffix(page, "\r\n\r\ncustom: too many connections"))
+}
+
+// failingListener fails the first failures calls to Accept and records
+// when each call happened.
+type failingListener struct {
+	*pipeListener
+	failures int
+
+	mu    sync.Mutex
+	calls []time.Time
+}
+
+func (l *failingListener) Accept() (net.Conn, error) {
+	l.mu.Lock()
+	l.calls = append(l.calls, time.Now())
+	fail := len(l.calls) <= l.failures
+	l.mu.Unlock()
+	if fail {
+		return nil, errors.New("accept: too many open files")
+	}
+	return l.pipeListener.Accept()
+}
+
+func TestAcceptBackoff(t *testing.T) {
+	// Test: Accept errors are retried with a growing delay
+	l := &failingListener{pipeListener: newPipeListener(), failures: 4}
+	s := New(hello)
+	defer s.Close()
+	require.NoError(t, s.ServeListener(l))
+	conn, err := l.Dial()
+	require.NoError(t, err)
+	assert.True(t, strings.HasSuffix(get(t, conn, "/"), "hello /"))
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	require.GreaterOrEqual(t, len(l.calls), 5)
+	for i, want := range []time.Duration{5, 10, 20, 40} {
+		assert.GreaterOrEqual(t, l.calls[i+1].Sub(l.calls[i]), want*time.Millisecond)
+	}
+}
@@ -0,0 +1,155 @@
+package server
+
+import (
+	"fmt"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// pipeListener is an in-memory listener, Dial hands out the client side
+// of a net.Pipe.
+type pipeListener struct {
+	conns     chan net.Conn
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+func newPipeListener() *pipeListener {
+	return &pipeListener{conns: make(chan net.Conn), closed: make(chan struct{})}
+}
+
+func (l *pipeListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *pipeListener) Close() error {
+	l.closeOnce.Do(func() { close(l.closed) })
+	return nil
+}
+
+func (l *pipeListener) Addr() net.Addr {
+	return pipeAddr{}
+}
+
+func (l *pipeListener) Dial() (net.Conn, error) {
+	client, server := net.Pipe()
+	select {
+	case l.conns <- server:
+		return client, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+type pipeAddr struct{}
+
+func (pipeAddr) Network() string { return "pipe" }
+func (pipeAddr) String() string  { return "pipe" }
+
+func hello(w *response.Writer, req *request.Request) {
+	fmt.Fprintf(w, "hello %s", req.URL.Path)
+}
+
+// get sends a GET over conn and returns the whole response.
+func get(t *testing.T, conn net.Conn, path string) string {
+	t.Helper()
+	defer conn.Close()
+	go io.WriteString(conn, "GET "+path+" HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	res, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	return string(res)
+}
+
+func TestServeListener(t *testing.T) {
+	s := New(hello)
+
+	// Test: In-memory listener
+	pipe := newPipeListener()
+	require.NoError(t, s.ServeListener(pipe))
+	conn, err := pipe.Dial()
+	require.NoError(t, err)
+	assert.True(t, strings.HasSuffix(get(t, conn, "/pipe"), "\r\n\r\nhello /pipe"))
+
+	// Test: Unix socket, replacing a stale socket file
+	path := filepath.Join(t.TempDir(), "http.sock")
+	stale, err := net.Listen("unix", path)
+	require.NoError(t, err)
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+	require.NoError(t, s.ListenUnix(path))
+	require.Error(t, s.ListenUnix(path))
+	conn, err = net.Dial("unix", path)
+	require.NoError(t, err)
+	assert.True(t, strings.HasSuffix(get(t, conn, "/unix"), "\r\n\r\nhello /unix"))
+
+	// Test: TCP next to the others
+	require.NoError(t, s.Listen(0))
+	addrs := s.Addrs()
+	require.Len(t, addrs, 3)
+	assert.Equal(t, "pipe", addrs[0].Network())
+	assert.Equal(t, "unix", addrs[1].Network())
+	conn, err = net.Dial("tcp", addrs[2].String())
+	require.NoError(t, err)
+	assert.True(t, strings.HasSuffix(get(t, conn, "/tcp"), "\r\n\r\nhello /tcp"))
+
+	// Test: Close closes all listeners
+	require.NoError(t, s.Close())
+	_, err = pipe.Dial()
+	require.ErrorIs(t, err, net.ErrClosed)
+	_, err = net.Dial("unix", path)
+	require.Error(t, err)
+	_, err = net.Dial("tcp", addrs[2].String())
+	require.Error(t, err)
+	assert.Empty(t, s.Addrs())
+	require.ErrorIs(t, s.ServeListener(newPipeListener()), ErrServerClosed)
+}
+
+func TestSystemdListeners(t *testing.T) {
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	file, err := tcp.(*net.TCPListener).File()
+	require.NoError(t, err)
+	defer file.Close()
+	tcp.Close()
+
+	// Test: Variables meant for another process are ignored
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()+1))
+	t.Setenv("LISTEN_FDS", "1")
+	listeners, err := listenersFromEnv(int(file.Fd()))
+	require.NoError(t, err)
+	assert.Empty(t, listeners)
+
+	// Test: Passed sockets become listeners
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	t.Setenv("LISTEN_FDNAMES", "http")
+	listeners, err = listenersFromEnv(int(file.Fd()))
+	require.NoError(t, err)
+	require.Len(t, listeners, 1)
+	s := New(hello)
+	defer s.Close()
+	require.NoError(t, s.ServeListener(listeners[0]))
+	conn, err := net.Dial("tcp", listeners[0].Addr().String())
+	require.NoError(t, err)
+	assert.True(t, strings.HasSuffix(get(t, conn, "/systemd"), "\r\n\r\nhello /systemd"))
+
+	// Test: Malformed count
+	t.Setenv("LISTEN_FDS", "x")
+	_, err = listenersFromEnv(int(file.Fd()))
+	require.Error(t, err)
+}
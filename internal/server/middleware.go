@@ -0,0 +1,40 @@
+package server
+
+import (
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"log"
+	"time"
+)
+
+// Middleware wraps a Handler to run code before and after it.
+type Middleware func(next Handler) Handler
+
+// Chain composes middleware into one. The first middleware is the
+// outermost, so it sees the request first and the response last.
+func Chain(middleware ...Middleware) Middleware {
+	return func(next Handler) Handler {
+		for i := len(middleware) - 1; i >= 0; i-- {
+			next = middleware[i](next)
+		}
+		return next
+	}
+}
+
+// Logging logs the method, target, status, body size and duration of
+// every request once it has been handled.
+func Logging(logger *log.Logger) Middleware {
+	return func(next Handler) Handler {
+		return func(w *response.Writer, req *request.Request) {
+			start := time.Now()
+			next(w, req)
+			logger.Printf("%s %s %d %dB %s",
+				req.RequestLine.Method,
+				req.RequestLine.RequestTarget,
+				w.StatusCode(),
+				w.BytesWritten(),
+				time.Since(start),
+			)
+		}
+	}
+}
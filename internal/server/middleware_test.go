@@ -0,0 +1,36 @@
+package server
+
+import (
+	"bytes"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"log"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogging(t *testing.T) {
+	// Test: A handler that writes nothing is logged with the 200 the
+	// client gets
+	logs := &bytes.Buffer{}
+	s := New(Chain(Logging(log.New(logs, "", 0)))(func(w *response.Writer, req *request.Request) {
+		if req.URL.Path == "/hello" {
+			hello(w, req)
+		}
+	}))
+	defer s.Close()
+	require.NoError(t, s.Listen(0))
+	for _, path := range []string{"/", "/hello"} {
+		conn, err := net.Dial("tcp", s.Addrs()[0].String())
+		require.NoError(t, err)
+		assert.True(t, strings.HasPrefix(get(t, conn, path), "HTTP/1.1 200 OK\r\n"))
+	}
+	lines := strings.Split(strings.TrimSpace(logs.String()), "\n")
+	require.Len(t, lines, 2)
+	assert.True(t, strings.HasPrefix(lines[0], "GET / 200 0B "), lines[0])
+	assert.True(t, strings.HasPrefix(lines[1], "GET /hello 200 12B "), lines[1])
+}
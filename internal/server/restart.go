@@ -0,0 +1,181 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// The environment of a process started by Restart says how many listeners
+// it inherited, starting at fd 3 like with systemd, and which fd reports
+// readiness.
+const (
+	listenFDsEnv = "HTTPFROMTCP_LISTEN_FDS"
+	readyFDEnv   = "HTTPFROMTCP_READY_FD"
+)
+
+// restartReadyTimeout is how long the new process gets to start accepting.
+const restartReadyTimeout = 30 * time.Second
+
+// Restart starts a new copy of the running binary, with the same arguments,
+// and passes it the server's listeners. It returns once the new process
+// called NotifyReady, and the caller should then Shutdown this server to
+// hand over. Both processes accept on the same sockets meanwhile, so no
+// connection is refused. If the new process fails to get ready, it is
+// killed and this server keeps serving.
+func (s *Server) Restart() (*os.Process, error) {
+	path, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	return s.restart(path, os.Args[1:])
+}
+
+func (s *Server) restart(path string, args []string) (*os.Process, error) {
+	readyR, readyW, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer readyR.Close()
+	process, err := s.startWithListeners(path, args, readyW)
+	readyW.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	// the pipe reports EOF if the child exits without getting ready
+	readyR.SetReadDeadline(time.Now().Add(restartReadyTimeout))
+	if _, err := readyR.Read(make([]byte, 1)); err != nil {
+		process.Kill()
+		process.Wait()
+		return nil, fmt.Errorf("new process did not get ready: %w", err)
+	}
+	go process.Wait()
+	return process, nil
+}
+
+// startWithListeners starts the new process with the listeners from fd 3
+// on, followed by ready. It uses syscall.ForkExec rather than os/exec:
+// handing an os.File to a child puts the socket in blocking mode, which is
+// shared with this process and would keep Close from interrupting Accept.
+func (s *Server) startWithListeners(path string, args []string, ready *os.File) (*os.Process, error) {
+	// holding mu keeps the listeners from being closed meanwhile
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.inShutdown.Load() {
+		return nil, ErrServerClosed
+	}
+
+	fds := []uintptr{0, 1, 2}
+	conns := []syscall.Conn{}
+	for _, l := range s.listeners {
+		conn, ok := l.(syscall.Conn)
+		if !ok {
+			return nil, fmt.Errorf("can't pass on a %s listener", l.Addr().Network())
+		}
+		conns = append(conns, conn)
+	}
+	conns = append(conns, ready)
+	for _, conn := range conns {
+		rawConn, err := conn.SyscallConn()
+		if err != nil {
+			return nil, err
+		}
+		rawConn.Control(func(fd uintptr) {
+			fds = append(fds, fd)
+		})
+	}
+	for _, l := range s.listeners {
+		if l, ok := l.(*net.UnixListener); ok {
+			// the socket file has to outlive this process
+			l.SetUnlinkOnClose(false)
+		}
+	}
+
+	env := append(restartEnviron(),
+		fmt.Sprintf("%s=%d", listenFDsEnv, len(s.listeners)),
+		fmt.Sprintf("%s=%d", readyFDEnv, listenFDsStart+len(s.listeners)),
+	)
+	pid, err := syscall.ForkExec(path, append([]string{path}, args...), &syscall.ProcAttr{
+		Env:   env,
+		Files: fds,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return os.FindProcess(pid)
+}
+
+// restartEnviron returns the environment without any variables meant for
+// this process only.
+func restartEnviron() []string {
+	var env []string
+	for _, kv := range os.Environ() {
+		name, _, _ := strings.Cut(kv, "=")
+		switch name {
+		case listenFDsEnv, readyFDEnv, "LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES":
+			continue
+		}
+		env = append(env, kv)
+	}
+	return env
+}
+
+// InheritedListeners returns the listeners passed on by Restart in the
+// parent process, in the order the parent had them. It returns none if the
+// process was not started by Restart.
+func InheritedListeners() ([]net.Listener, error) {
+	value, ok := os.LookupEnv(listenFDsEnv)
+	if !ok {
+		return nil, nil
+	}
+	os.Unsetenv(listenFDsEnv)
+	count, err := strconv.Atoi(value)
+	if err != nil || count < 0 {
+		return nil, fmt.Errorf("invalid %s: %q", listenFDsEnv, value)
+	}
+
+	listeners := make([]net.Listener, 0, count)
+	for i := range count {
+		file := os.NewFile(uintptr(listenFDsStart+i), fmt.Sprintf("inherited listener %d", i))
+		l, err := net.FileListener(file)
+		file.Close()
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, err
+		}
+		if l, ok := l.(*net.UnixListener); ok {
+			// this process owns the socket file now
+			l.SetUnlinkOnClose(true)
+		}
+		listeners = append(listeners, l)
+	}
+	return listeners, nil
+}
+
+// NotifyReady tells the parent that started this process with Restart that
+// it is accepting connections, so the parent can shut down. It does
+// nothing if the process was not started by Restart.
+func NotifyReady() error {
+	value, ok := os.LookupEnv(readyFDEnv)
+	if !ok {
+		return nil
+	}
+	os.Unsetenv(readyFDEnv)
+	fd, err := strconv.Atoi(value)
+	if err != nil || fd < 3 {
+		return fmt.Errorf("invalid %s: %q", readyFDEnv, value)
+	}
+	ready := os.NewFile(uintptr(fd), "ready")
+	defer ready.Close()
+	if _, err := ready.Write([]byte{1}); err != nil {
+		return fmt.Errorf("notifying the parent process: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestRestartChild is the new process started by TestRestart.
+func TestRestartChild(t *testing.T) {
+	if os.Getenv(listenFDsEnv) == "" {
+		t.Skip("only run by TestRestart")
+	}
+	listeners, err := InheritedListeners()
+	require.NoError(t, err)
+	s := New(func(w *response.Writer, req *request.Request) {
+		fmt.Fprintf(w, "child %s", req.URL.Path)
+	})
+	for _, l := range listeners {
+		require.NoError(t, s.ServeListener(l))
+	}
+	require.NoError(t, NotifyReady())
+	time.Sleep(10 * time.Second)
+}
+
+func TestRestart(t *testing.T) {
+	s := New(func(w *response.Writer, req *request.Request) {
+		fmt.Fprintf(w, "parent %s", req.URL.Path)
+	})
+	defer s.Close()
+	require.NoError(t, s.Listen(0))
+	path := filepath.Join(t.TempDir(), "http.sock")
+	require.NoError(t, s.ListenUnix(path))
+	addr := s.Addrs()[0].String()
+
+	// Test: A child that doesn't get ready is reported
+	falsePath, err := exec.LookPath("false")
+	require.NoError(t, err)
+	_, err = s.restart(falsePath, nil)
+	require.Error(t, err)
+
+	// Test: The child takes over the listeners while the parent drains,
+	// and no request fails in between
+	const clients = 8
+	stop := make(chan struct{})
+	results := make(chan map[string]int)
+	for range clients {
+		go func() {
+			served := map[string]int{}
+			for i := 0; ; i++ {
+				select {
+				case <-stop:
+					results <- served
+					return
+				default:
+				}
+				served[requestDuringRestart(addr, i)]++
+			}
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	process, err := s.restart(os.Args[0], []string{"-test.run=^TestRestartChild$"})
+	require.NoError(t, err)
+	defer process.Kill()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, s.Shutdown(ctx))
+	time.Sleep(50 * time.Millisecond)
+	close(stop)
+	served := map[string]int{}
+	for range clients {
+		for process, n := range <-results {
+			served[process] += n
+		}
+	}
+	assert.Equal(t, 0, served["failed"], "%v", served)
+	assert.Greater(t, served["parent"], 0)
+	assert.Greater(t, served["child"], 0)
+
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	assert.True(t, strings.HasSuffix(get(t, conn, "/tcp"), "\r\n\r\nchild /tcp"))
+	conn, err = net.Dial("unix", path)
+	require.NoError(t, err)
+	assert.True(t, strings.HasSuffix(get(t, conn, "/unix"), "\r\n\r\nchild /unix"))
+
+	// Test: In-memory listeners can't be passed on
+	s = New(hello)
+	defer s.Close()
+	require.NoError(t, s.ServeListener(newPipeListener()))
+	_, err = s.restart(falsePath, nil)
+	require.Error(t, err)
+}
+
+// requestDuringRestart sends request i to addr and returns which process
+// answered it, or "failed".
+func requestDuringRestart(addr string, i int) string {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return "failed"
+	}
+	defer conn.Close()
+	path := fmt.Sprintf("/%d", i)
+	if _, err := io.WriteString(conn, "GET "+path+" HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n"); err != nil {
+		return "failed"
+	}
+	res, err := io.ReadAll(conn)
+	if err != nil {
+		return "failed"
+	}
+	for _, process := range []string{"parent", "child"} {
+		if strings.HasSuffix(string(res), "\r\n\r\n"+process+" "+path) {
+			return process
+		}
+	}
+	return "failed"
+}
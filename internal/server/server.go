@@ -1,75 +1,546 @@
 package server
 
 import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"httpfromtcp/internal/headers"
 	"httpfromtcp/internal/request"
 	"httpfromtcp/internal/response"
+	"io"
 	"log"
 	"net"
+	"os"
+	"runtime/debug"
+	"sync"
 	"sync/atomic"
+	"time"
 )
 
-type Handler func(w *response.Writer, req *request.Request) 
+type Handler func(w *response.Writer, req *request.Request)
 
+// ErrorHandler writes the response for a request the server could not get
+// a proper answer for. req is nil if the request could not be parsed.
+type ErrorHandler func(w *response.Writer, req *request.Request, statusCode response.StatusCode, err error)
+
+const (
+	defaultReadHeaderTimeout = 10 * time.Second
+	defaultReadTimeout       = 5 * time.Minute
+	defaultWriteTimeout      = 10 * time.Minute
+	defaultIdleTimeout       = 2 * time.Minute
+)
+
+// For all timeouts zero means the default and a negative value means no
+// timeout at all. The fields must be set before the first Listen or
+// ServeListener and not changed after.
 type Server struct {
+	// ReadHeaderTimeout is how long a client may take to send the request
+	// line and headers, counted from the first byte of the request, or from
+	// accepting the connection for the first request.
+	ReadHeaderTimeout time.Duration
+	// ReadTimeout is how long a client may take to send the whole request,
+	// including its body.
+	ReadTimeout time.Duration
+	// WriteTimeout is how long the handler may take to write the response,
+	// counted from the end of the request headers.
+	WriteTimeout time.Duration
+	// IdleTimeout is how long a keep-alive connection may wait for the
+	// next request before it is closed.
+	IdleTimeout time.Duration
+	// Limits caps the size of incoming requests.
+	request.Limits
+	// ObsFold says what to do with header lines continued by obs-fold.
+	// The default rejects the request.
+	ObsFold headers.ObsFoldPolicy
+
+	// ErrorHandler writes error responses, e.g. the 500 after a handler
+	// panicked. Nil means a plain HTML error page.
+	ErrorHandler ErrorHandler
+
+	// MaxConns caps the number of connections served at once, over all
+	// listeners. Once it is reached the server stops accepting until a
+	// connection closes, or with RejectOverLimit answers new ones with
+	// 503. Zero means no limit.
+	MaxConns int
+	// RejectOverLimit makes the server answer connections over MaxConns
+	// with 503 rather than leaving them in the listen backlog.
+	RejectOverLimit bool
+	// MaxConnsPerIP caps the number of connections from one client IP
+	// address. Connections over it are answered with 503. Zero means no
+	// limit.
+	MaxConnsPerIP int
+
+	// ConnState is called whenever a connection changes state, from the
+	// connection's goroutine. Nil means no hook.
+	ConnState func(net.Conn, State)
+
 	inShutdown atomic.Bool
-	listener net.Listener
-	handler Handler
+	handler    Handler
+
+	mu        sync.Mutex
+	listeners []net.Listener
+	// conns maps every open connection to its state
+	conns map[net.Conn]connStatus
+	// closed is set by Close, new connections are not served after it
+	closed bool
+	// slots holds a token for every connection counted against MaxConns
+	slots      chan struct{}
+	connsPerIP map[string]int
+
+	active     atomic.Int64
+	accepted   atomic.Int64
+	rejected   atomic.Int64
+	lastConnID atomic.Uint64
+}
+
+// ErrServerClosed is returned when a listener is added to a server that
+// is closed or shutting down.
+var ErrServerClosed = errors.New("server closed")
+
+// shutdownPollInterval is how often Shutdown checks whether the active
+// connections are done.
+const shutdownPollInterval = 100 * time.Millisecond
+
+// newConnGrace is how long Shutdown gives a new connection to send its
+// first request before treating it as idle. The client may have sent it
+// just before the listener closed.
+var newConnGrace = 5 * time.Second
+
+// connStatus is what the server tracks about an open connection.
+type connStatus struct {
+	state State
+	// since is when the connection entered state
+	since time.Time
+}
+
+// errorWriteTimeout bounds writing an error response to a client that is
+// about to be disconnected anyway.
+const errorWriteTimeout = 5 * time.Second
+
+func New(handler Handler) *Server {
+	return &Server{
+		handler: handler,
+	}
 }
 
 func Serve(port int, handler Handler) (*Server, error) {
+	s := New(handler)
+	if err := s.Listen(port); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Listen starts accepting connections on the given port in the background.
+func (s *Server) Listen(port int) error {
 	// Listen on TCP port 2000 on all available unicast and
 	// anycast IP addresses of the local system.
 	l, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
 	if err != nil {
-		return nil, err
+		return err
 	}
-	s := &Server{
-		listener: l,
-		handler: handler,
+	return s.ServeListener(l)
+}
+
+// ListenUnix starts accepting connections on a Unix domain socket at path
+// in the background. A socket file left over from an earlier run is
+// removed first.
+func (s *Server) ListenUnix(path string) error {
+	if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return fmt.Errorf("%s is in use", path)
+		}
+		os.Remove(path)
 	}
-	go s.listen()
-	return  s, nil
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+	return s.ServeListener(l)
+}
+
+// ServeListener starts accepting connections on l in the background. A
+// server can serve any number of listeners, e.g. TCP on IPv4 and IPv6 and
+// a Unix socket. l is closed by Close and Shutdown.
+func (s *Server) ServeListener(l net.Listener) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.inShutdown.Load() {
+		l.Close()
+		return ErrServerClosed
+	}
+	s.listeners = append(s.listeners, l)
+	go s.listen(l)
+	return nil
+}
+
+// Addrs returns the addresses of the listeners, in the order they were
+// added.
+func (s *Server) Addrs() []net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	addrs := make([]net.Addr, len(s.listeners))
+	for i, l := range s.listeners {
+		addrs[i] = l.Addr()
+	}
+	return addrs
 }
 
+// Close stops accepting connections and closes all open ones right away,
+// including those in the middle of a response.
 func (s *Server) Close() error {
+	err := s.closeListeners()
+	s.mu.Lock()
+	s.closed = true
+	for conn := range s.conns {
+		conn.Close()
+		delete(s.conns, conn)
+	}
+	s.mu.Unlock()
+	return err
+}
+
+// Shutdown stops accepting connections, closes idle ones and waits for
+// in-flight requests to finish. Connections are closed as soon as their
+// current response is done. If ctx expires first, the remaining connections
+// are closed and ctx's error is returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	err := s.closeListeners()
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for {
+		if s.closeIdleConns() {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			s.Close()
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Server) closeListeners() error {
 	s.inShutdown.Store(true)
-	if s.listener != nil {
-		return s.listener.Close()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var errs []error
+	for _, l := range s.listeners {
+		if err := l.Close(); err != nil {
+			errs = append(errs, err)
+		}
 	}
-	return nil
+	s.listeners = nil
+	return errors.Join(errs...)
+}
+
+// closeIdleConns closes all connections waiting for a request and reports
+// whether that left no connections open. New connections are given
+// newConnGrace to send their first request.
+func (s *Server) closeIdleConns() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn, status := range s.conns {
+		idle := status.state == StateIdle ||
+			status.state == StateNew && time.Since(status.since) >= newConnGrace
+		if idle {
+			conn.Close()
+			delete(s.conns, conn)
+		}
+	}
+	// active also counts connections accepted but not tracked yet
+	return len(s.conns) == 0 && s.active.Load() == 0
+}
+
+// trackConn registers or forgets a connection. It returns false if the
+// server was closed and the connection should not be served. During a
+// Shutdown it is still served, the client may have sent a request already.
+func (s *Server) trackConn(conn net.Conn, add bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !add {
+		delete(s.conns, conn)
+		return true
+	}
+	if s.closed {
+		return false
+	}
+	if s.conns == nil {
+		s.conns = map[net.Conn]connStatus{}
+	}
+	s.conns[conn] = connStatus{state: StateNew, since: time.Now()}
+	return true
 }
 
-func (s *Server) listen() {
+// hijack hands conn over to a handler. The server forgets about it, so
+// Close and Shutdown leave it open.
+func (s *Server) hijack(conn net.Conn, reader *request.Reader) (net.Conn, error) {
+	s.mu.Lock()
+	if _, ok := s.conns[conn]; !ok {
+		s.mu.Unlock()
+		return nil, ErrServerClosed
+	}
+	delete(s.conns, conn)
+	s.mu.Unlock()
+	conn.SetDeadline(time.Time{})
+	s.connState(conn, StateHijacked)
+	return &hijackedConn{
+		Conn:   conn,
+		reader: io.MultiReader(bytes.NewReader(reader.Buffered()), conn),
+	}, nil
+}
+
+// deadline returns the deadline for a timeout starting at start, or the
+// zero time if the timeout is disabled.
+func deadline(start time.Time, timeout, defaultTimeout time.Duration) time.Time {
+	if timeout == 0 {
+		timeout = defaultTimeout
+	}
+	if timeout < 0 {
+		return time.Time{}
+	}
+	return start.Add(timeout)
+}
 
+func (s *Server) listen(l net.Listener) {
+	var backoff time.Duration
+	var slots chan struct{}
+	if !s.RejectOverLimit {
+		slots = s.connSlots()
+	}
 	for {
+		if slots != nil {
+			// don't take connections off the backlog that can't be served
+			slots <- struct{}{}
+		}
 		// Wait for a connection.
-		conn, err := s.listener.Accept()
+		conn, err := l.Accept()
 		if err != nil {
-			if s.inShutdown.Load() {
+			if slots != nil {
+				<-slots
+			}
+			if s.inShutdown.Load() || errors.Is(err, net.ErrClosed) {
 				return
 			}
-			log.Printf("Error accepting connection: %s", err)
+			backoff = nextBackoff(backoff)
+			log.Printf("Error accepting connection: %s; retrying in %s", err, backoff)
+			time.Sleep(backoff)
+			continue
+		}
+		backoff = 0
+		s.accepted.Add(1)
+		adm, err := s.admit(conn, slots)
+		if err != nil {
+			go s.reject(conn, err)
 			continue
 		}
 		// Handle the connection in a new goroutine.
 		// The loop then returns to accepting, so that
 		// multiple connections may be served concurrently.
-		go s.handle(conn) 
+		go s.handle(conn, adm)
 	}
 }
 
-func (s *Server) handle(conn net.Conn) {
-	defer conn.Close()
-	req, err := request.RequestFromReader(conn)
-	resW := response.NewWriter(conn)
-	fmt.Printf("target: %v\n", req.RequestLine.RequestTarget)
-	if err != nil {
-		errorMessage := []byte(fmt.Sprintf("Error while parsing request: %v", err))
-		resW.WriteStatusLine(response.StatusBadRequest)
-		resW.WriteHeaders(response.GetDefaultHeaders(len(errorMessage)))
-		resW.WriteBody(errorMessage)
+func (s *Server) handle(conn net.Conn, adm admission) {
+	hijacked := false
+	defer func() {
+		if !hijacked {
+			conn.Close()
+			s.connState(conn, StateClosed)
+		}
+		s.release(adm)
+	}()
+	s.connState(conn, StateNew)
+	if !s.trackConn(conn, true) {
+		return
+	}
+	defer s.trackConn(conn, false)
+	reader := request.NewReader(conn)
+	reader.Limits = s.Limits
+	reader.ObsFold = s.ObsFold
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ctx = context.WithValue(ctx, connInfoKey{}, ConnInfo{
+		ID:         s.lastConnID.Add(1),
+		RemoteAddr: conn.RemoteAddr(),
+		LocalAddr:  conn.LocalAddr(),
+	})
+
+	firstRequest := true
+	// during a shutdown, only the request a new connection was opened for
+	// is served
+	for firstRequest || !s.inShutdown.Load() {
+		// wait for the next request, but not forever
+		waitStart := time.Now()
+		isFirst := firstRequest
+		if isFirst {
+			conn.SetReadDeadline(deadline(waitStart, s.ReadHeaderTimeout, defaultReadHeaderTimeout))
+		} else {
+			conn.SetReadDeadline(deadline(waitStart, s.IdleTimeout, defaultIdleTimeout))
+		}
+		firstRequest = false
+		if err := reader.Ready(); err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, os.ErrDeadlineExceeded) && !s.inShutdown.Load() {
+				log.Printf("Error reading from connection: %s", err)
+			}
+			return
+		}
+		if !s.setState(conn, StateActive) {
+			return
+		}
+
+		requestStart := time.Now()
+		if isFirst {
+			// the deadline set at accept stands, sending the first byte
+			// late must not buy a slow client more time
+			requestStart = waitStart
+		} else {
+			conn.SetReadDeadline(deadline(requestStart, s.ReadHeaderTimeout, defaultReadHeaderTimeout))
+		}
+		req, err := reader.ReadRequestHeaders()
+		resW := response.NewWriter(conn)
+		if err != nil {
+			var parseErr *request.ParseError
+			if errors.As(err, &parseErr) {
+				// the framing can't be trusted anymore, answer and hang up
+				resW.SetKeepAlive(false)
+				conn.SetWriteDeadline(time.Now().Add(errorWriteTimeout))
+				if !s.writeError(resW, nil, response.StatusCode(parseErr.StatusCode), parseErr) {
+					abort(conn)
+					return
+				}
+				resW.Finish()
+			} else if errors.Is(err, os.ErrDeadlineExceeded) {
+				resW.SetKeepAlive(false)
+				conn.SetWriteDeadline(time.Now().Add(errorWriteTimeout))
+				if !s.writeError(resW, nil, response.StatusRequestTimeout, errors.New("timed out reading the request headers")) {
+					abort(conn)
+					return
+				}
+				resW.Finish()
+			} else if !errors.Is(err, io.ErrUnexpectedEOF) {
+				log.Printf("Error reading request: %s", err)
+			}
+			return
+		}
+		conn.SetReadDeadline(deadline(requestStart, s.ReadTimeout, defaultReadTimeout))
+		conn.SetWriteDeadline(deadline(time.Now(), s.WriteTimeout, defaultWriteTimeout))
+		if tlsConn, ok := conn.(*tls.Conn); ok {
+			state := tlsConn.ConnectionState()
+			req.TLS = &state
+		}
+		req.SetContext(ctx)
+
+		resW.SetClientVersion(req.RequestLine.HttpVersion)
+		resW.SetRequestMethod(req.RequestLine.Method)
+		resW.SetKeepAlive(req.KeepAlive() && !s.inShutdown.Load())
+		resW.SetHijacker(func() (net.Conn, error) {
+			hijackedConn, err := s.hijack(conn, reader)
+			hijacked = err == nil
+			return hijackedConn, err
+		})
+		ok := s.serveRequest(resW, req)
+		if hijacked {
+			return
+		}
+		if !ok {
+			abort(conn)
+			return
+		}
+		if s.inShutdown.Load() {
+			// a shutdown started meanwhile, headers that didn't go out yet
+			// can still say so
+			resW.SetKeepAlive(false)
+		}
+		if err := resW.Finish(); err != nil {
+			return
+		}
+		// the next request starts where this body ends
+		if err := req.BodyReader.Close(); err != nil {
+			return
+		}
+		if !resW.KeepAlive() || !s.setState(conn, StateIdle) {
+			return
+		}
+	}
+}
+
+// serveRequest runs the handler, recovering from panics. It returns false
+// if the handler panicked after the status line went out, in which case the
+// response is broken and the connection has to be aborted.
+func (s *Server) serveRequest(w *response.Writer, req *request.Request) (ok bool) {
+	defer func() {
+		rec := recover()
+		if rec == nil {
+			return
+		}
+		log.Printf("Panic serving %s %s: %v\n%s", req.RequestLine.Method, req.RequestLine.RequestTarget, rec, debug.Stack())
+		// a response buffered by Write is dropped, one that went out
+		// in part can't be fixed
+		if err := w.Reset(); err != nil {
+			ok = false
+			return
+		}
+		w.SetKeepAlive(false)
+		ok = s.writeError(w, req, response.StatusServerError, fmt.Errorf("panic: %v", rec))
+	}()
+
+	s.handler(w, req)
+	return true
+}
+
+// writeError writes an error response with the ErrorHandler, or the default
+// page if there is none or it panics. Like serveRequest, it returns false if
+// the response is broken and the connection has to be aborted.
+func (s *Server) writeError(w *response.Writer, req *request.Request, statusCode response.StatusCode, err error) (ok bool) {
+	if s.ErrorHandler == nil {
+		defaultErrorHandler(w, req, statusCode, err)
+		return true
+	}
+	defer func() {
+		rec := recover()
+		if rec == nil {
+			return
+		}
+		log.Printf("Panic in the error handler for %d %v: %v\n%s", statusCode, err, rec, debug.Stack())
+		if err := w.Reset(); err != nil {
+			ok = false
+			return
+		}
+		defaultErrorHandler(w, req, statusCode, err)
+		ok = true
+	}()
+
+	s.ErrorHandler(w, req, statusCode, err)
+	return true
+}
+
+func defaultErrorHandler(w *response.Writer, _ *request.Request, statusCode response.StatusCode, err error) {
+	message := err.Error()
+	if statusCode >= 500 {
+		// don't leak panics and internals to the client
+		message = "Something went wrong on our side."
+	}
+	body := response.ErrorPage(statusCode, message)
+	w.WriteStatusLine(statusCode)
+	w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+	w.WriteBody(body)
+}
+
+// abort closes the connection with a TCP reset, so the client can tell the
+// response was cut off rather than complete.
+func abort(conn net.Conn) {
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		conn = tlsConn.NetConn()
+	}
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		tcpConn.SetLinger(0)
 	}
-	
-	s.handler(resW, req)
+	conn.Close()
 }
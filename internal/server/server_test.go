@@ -0,0 +1,335 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"io"
+	"net"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func panicking(w *response.Writer, req *request.Request) {
+	if req.URL.Path == "/late" {
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(response.GetDefaultHeaders(100))
+		w.WriteBody([]byte("partial"))
+	}
+	panic("handler bug")
+}
+
+func TestHandlerPanic(t *testing.T) {
+	s := New(panicking)
+	defer s.Close()
+	require.NoError(t, s.Listen(0))
+
+	// Test: A panic before any output gets a 500, and the connection is
+	// closed cleanly after it
+	conn, err := net.Dial("tcp", s.Addrs()[0].String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = io.WriteString(conn, "GET /early HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	res, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(res), "HTTP/1.1 500 Internal Server Error\r\n"))
+	assert.Contains(t, string(res), "Connection: close\r\n")
+	assert.NotContains(t, string(res), "handler bug")
+
+	// Test: A panic after the status line went out aborts the connection,
+	// so the client can tell the response is broken
+	conn, err = net.Dial("tcp", s.Addrs()[0].String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = io.WriteString(conn, "GET /late HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	_, err = io.ReadAll(conn)
+	require.ErrorIs(t, err, syscall.ECONNRESET)
+}
+
+func TestErrorHandlerPanic(t *testing.T) {
+	s := New(panicking)
+	s.ErrorHandler = func(w *response.Writer, req *request.Request, statusCode response.StatusCode, err error) {
+		// req is nil for requests that could not be parsed
+		if req.URL.Path == "/late" {
+			w.WriteStatusLine(statusCode)
+		}
+		panic("error handler bug")
+	}
+	defer s.Close()
+	require.NoError(t, s.Listen(0))
+
+	// Test: The default page stands in for an ErrorHandler that panics on a
+	// parse error
+	conn, err := net.Dial("tcp", s.Addrs()[0].String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = io.WriteString(conn, "BAD\r\n\r\n")
+	require.NoError(t, err)
+	res, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(res), "HTTP/1.1 400 Bad Request\r\n"))
+	assert.Contains(t, string(res), "Connection: close\r\n")
+
+	// Test: ... and for the 500 after a handler panicked
+	conn, err = net.Dial("tcp", s.Addrs()[0].String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = io.WriteString(conn, "GET /early HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	res, err = io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(res), "HTTP/1.1 500 Internal Server Error\r\n"))
+	assert.NotContains(t, string(res), "bug")
+
+	// Test: An ErrorHandler that panics after its status line went out
+	// aborts the connection
+	conn, err = net.Dial("tcp", s.Addrs()[0].String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = io.WriteString(conn, "GET /late HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	_, err = io.ReadAll(conn)
+	require.ErrorIs(t, err, syscall.ECONNRESET)
+
+	// Test: The server is still up
+	conn, err = net.Dial("tcp", s.Addrs()[0].String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = io.WriteString(conn, "BAD\r\n\r\n")
+	require.NoError(t, err)
+	res, err = io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(res), "HTTP/1.1 400 Bad Request\r\n"))
+}
+
+// writeErrorPage is an ErrorHandler that leaves the framing to Write.
+func writeErrorPage(w *response.Writer, _ *request.Request, statusCode response.StatusCode, err error) {
+	w.WriteStatusLine(statusCode)
+	w.Write([]byte("custom: " + err.Error()))
+}
+
+func TestErrorHandlerWrite(t *testing.T) {
+	s := New(panicking)
+	s.ErrorHandler = writeErrorPage
+	s.ReadHeaderTimeout = 100 * time.Millisecond
+	defer s.Close()
+	require.NoError(t, s.Listen(0))
+
+	for _, tt := range []struct {
+		name, data, want string
+	}{
+		{"parse error", "BAD\r\n\r\n", "HTTP/1.1 400 Bad Request\r\n"},
+		{"timeout", "GET / HTTP/1.1\r\n", "HTTP/1.1 408 Request Timeout\r\n"},
+		{"panic", "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n", "HTTP/1.1 500 Internal Server Error\r\n"},
+	} {
+		// Test: The body an ErrorHandler wrote with Write is sent
+		conn, err := net.Dial("tcp", s.Addrs()[0].String())
+		require.NoError(t, err)
+		defer conn.Close()
+		_, err = io.WriteString(conn, tt.data)
+		require.NoError(t, err)
+		res, err := io.ReadAll(conn)
+		require.NoError(t, err, tt.name)
+		assert.True(t, strings.HasPrefix(string(res), tt.want), tt.name)
+		assert.Contains(t, string(res), "Connection: close\r\n", tt.name)
+		assert.Regexp(t, "Content-Length: \\d+\r\n(.*\r\n)*\r\ncustom: ", string(res), tt.name)
+	}
+}
+
+// shutdown runs Shutdown in the background and returns its result.
+func shutdown(s *Server, timeout time.Duration) <-chan error {
+	done := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		done <- s.Shutdown(ctx)
+	}()
+	return done
+}
+
+func TestShutdown(t *testing.T) {
+	release := make(chan struct{})
+	s := New(func(w *response.Writer, req *request.Request) {
+		if req.URL.Path == "/slow" {
+			<-release
+		}
+		hello(w, req)
+	})
+	require.NoError(t, s.Listen(0))
+	addr := s.Addrs()[0].String()
+
+	// Test: An idle keep-alive connection is closed, a request in flight
+	// finishes and closes its connection
+	idle, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer idle.Close()
+	_, err = io.WriteString(idle, "GET /first HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	idleReader := bufio.NewReader(idle)
+	assert.Equal(t, "HTTP/1.1 200 OK hello /first", readResponse(t, idleReader))
+
+	slow, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer slow.Close()
+	_, err = io.WriteString(slow, "GET /slow HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	require.Eventually(t, func() bool { return s.ConnStats().Active == 2 }, time.Second, time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+
+	done := shutdown(s, 5*time.Second)
+	_, err = idleReader.ReadByte()
+	require.ErrorIs(t, err, io.EOF)
+	select {
+	case err := <-done:
+		t.Fatalf("Shutdown returned before the request finished: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	res, err := io.ReadAll(slow)
+	require.NoError(t, err)
+	assert.Contains(t, string(res), "Connection: close\r\n")
+	assert.True(t, strings.HasSuffix(string(res), "hello /slow"))
+	require.NoError(t, <-done)
+
+	// Test: A connection accepted before the listener closed still gets its
+	// request served
+	s = New(hello)
+	require.NoError(t, s.Listen(0))
+	conn, err := net.Dial("tcp", s.Addrs()[0].String())
+	require.NoError(t, err)
+	defer conn.Close()
+	require.Eventually(t, func() bool { return s.ConnStats().Active == 1 }, time.Second, time.Millisecond)
+	done = shutdown(s, 5*time.Second)
+	time.Sleep(50 * time.Millisecond)
+	assert.True(t, strings.HasSuffix(get(t, conn, "/late"), "hello /late"))
+	require.NoError(t, <-done)
+
+	// Test: A new connection that sends nothing is closed after the grace
+	// period
+	defer func(grace time.Duration) { newConnGrace = grace }(newConnGrace)
+	newConnGrace = 50 * time.Millisecond
+	s = New(hello)
+	require.NoError(t, s.Listen(0))
+	conn, err = net.Dial("tcp", s.Addrs()[0].String())
+	require.NoError(t, err)
+	defer conn.Close()
+	require.Eventually(t, func() bool { return s.ConnStats().Active == 1 }, time.Second, time.Millisecond)
+	require.NoError(t, <-shutdown(s, 5*time.Second))
+	_, err = conn.Read(make([]byte, 1))
+	require.ErrorIs(t, err, io.EOF)
+
+	// Test: When ctx expires, the remaining connections are closed
+	release = make(chan struct{})
+	defer close(release)
+	s = New(func(w *response.Writer, req *request.Request) {
+		<-release
+	})
+	require.NoError(t, s.Listen(0))
+	conn, err = net.Dial("tcp", s.Addrs()[0].String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = io.WriteString(conn, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	require.Eventually(t, func() bool { return s.ConnStats().Active == 1 }, time.Second, time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+	require.ErrorIs(t, <-shutdown(s, 50*time.Millisecond), context.DeadlineExceeded)
+	_, err = conn.Read(make([]byte, 1))
+	require.ErrorIs(t, err, io.EOF)
+}
+
+func TestKeepAlive(t *testing.T) {
+	s := New(hello)
+	defer s.Close()
+	require.NoError(t, s.Listen(0))
+	conn, err := net.Dial("tcp", s.Addrs()[0].String())
+	require.NoError(t, err)
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+
+	// Test: Requests follow each other on one connection
+	_, err = io.WriteString(conn, "GET /a HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 200 OK hello /a", readResponse(t, r))
+
+	// Test: A response to HEAD has the Content-Length but no body, so the
+	// next response starts right after its headers
+	_, err = io.WriteString(conn, "HEAD /x HTTP/1.1\r\nHost: localhost\r\n\r\nGET /y HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	require.NoError(t, err)
+	statusLine, err := r.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n", statusLine)
+	for {
+		line, err := r.ReadString('\n')
+		require.NoError(t, err)
+		if line == "\r\n" {
+			break
+		}
+		if strings.HasPrefix(line, "Content-Length:") {
+			assert.Equal(t, "Content-Length: 8\r\n", line)
+		}
+	}
+	assert.Equal(t, "HTTP/1.1 200 OK hello /y", readResponse(t, r))
+
+	// Test: Connection: close ends it
+	_, err = io.WriteString(conn, "GET /z HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 200 OK hello /z", readResponse(t, r))
+	_, err = r.ReadByte()
+	require.ErrorIs(t, err, io.EOF)
+}
+
+func TestTimeouts(t *testing.T) {
+	s := New(hello)
+	s.ReadHeaderTimeout = 200 * time.Millisecond
+	s.IdleTimeout = 150 * time.Millisecond
+	defer s.Close()
+	require.NoError(t, s.Listen(0))
+	addr := s.Addrs()[0].String()
+
+	// Test: A client that never sends a request is closed without an answer
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	res, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Empty(t, res)
+
+	// Test: Headers that come in too slowly get a 408. The timeout counts
+	// from accepting the connection, not from the first byte
+	conn, err = net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	start := time.Now()
+	time.Sleep(150 * time.Millisecond)
+	_, err = io.WriteString(conn, "GET / HTTP/1.1\r\n")
+	require.NoError(t, err)
+	res, err = io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(res), "HTTP/1.1 408 Request Timeout\r\n"))
+	assert.Less(t, time.Since(start), 300*time.Millisecond)
+
+	// Test: The header timeout restarts with each request on a keep-alive
+	// connection, and an idle one is closed after IdleTimeout
+	conn, err = net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for _, path := range []string{"/1", "/2"} {
+		time.Sleep(120 * time.Millisecond)
+		_, err = io.WriteString(conn, "GET "+path+" HTTP/1.1\r\nHost: localhost\r\n\r\n")
+		require.NoError(t, err)
+		assert.Equal(t, "HTTP/1.1 200 OK hello "+path, readResponse(t, r))
+	}
+	idleStart := time.Now()
+	_, err = r.ReadByte()
+	require.ErrorIs(t, err, io.EOF)
+	assert.GreaterOrEqual(t, time.Since(idleStart), 140*time.Millisecond)
+}
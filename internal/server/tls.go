@@ -0,0 +1,178 @@
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// certCheckInterval is how often the certificate files are checked for
+// changes, at most once per handshake.
+var certCheckInterval = 10 * time.Second
+
+// Certificate is a certificate and key pair on disk. The pair is loaded
+// again when either file changes, so renewed certificates are picked up
+// without a restart.
+type Certificate struct {
+	CertFile string
+	KeyFile  string
+}
+
+// TLSConfig configures TLS termination.
+type TLSConfig struct {
+	// Certificates are chosen by the server name the client asks for
+	// (SNI): the first one that is valid for it, or the first one if none
+	// is.
+	Certificates []Certificate
+	// Config is the base configuration, e.g. for MinVersion or client
+	// certificates with ClientAuth and ClientCAs. Its certificate fields
+	// are ignored. Nil means the defaults.
+	Config *tls.Config
+}
+
+// ServeTLS is like Serve, but for HTTPS with the certificate and key in
+// the given files.
+func ServeTLS(port int, certFile, keyFile string, handler Handler) (*Server, error) {
+	return ServeTLSConfig(port, &TLSConfig{
+		Certificates: []Certificate{{CertFile: certFile, KeyFile: keyFile}},
+	}, handler)
+}
+
+// ServeTLSConfig is like ServeTLS, with SNI and the rest of config.
+func ServeTLSConfig(port int, config *TLSConfig, handler Handler) (*Server, error) {
+	s := New(handler)
+	if err := s.ListenTLS(port, config); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// ListenTLS starts accepting TLS connections on the given port in the
+// background. The certificates are loaded up front, so missing or broken
+// files are reported here.
+func (s *Server) ListenTLS(port int, config *TLSConfig) error {
+	tlsConfig, err := config.build()
+	if err != nil {
+		return err
+	}
+	l, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return err
+	}
+	return s.ServeListener(tls.NewListener(l, tlsConfig))
+}
+
+// ServeListenerTLS is like ServeListener, but terminates TLS on the
+// connections l accepts.
+func (s *Server) ServeListenerTLS(l net.Listener, config *TLSConfig) error {
+	tlsConfig, err := config.build()
+	if err != nil {
+		l.Close()
+		return err
+	}
+	return s.ServeListener(tls.NewListener(l, tlsConfig))
+}
+
+// build loads the certificates and returns the tls.Config to serve with.
+func (c *TLSConfig) build() (*tls.Config, error) {
+	if len(c.Certificates) == 0 {
+		return nil, errors.New("no TLS certificates configured")
+	}
+	certs := make([]*reloadingCert, len(c.Certificates))
+	for i, files := range c.Certificates {
+		certs[i] = &reloadingCert{files: files}
+		if err := certs[i].load(); err != nil {
+			return nil, err
+		}
+	}
+
+	tlsConfig := &tls.Config{}
+	if c.Config != nil {
+		tlsConfig = c.Config.Clone()
+	}
+	tlsConfig.Certificates = nil
+	tlsConfig.GetConfigForClient = nil
+	tlsConfig.NextProtos = []string{"http/1.1"}
+	tlsConfig.GetCertificate = func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		for _, cert := range certs {
+			if c := cert.get(); hello.SupportsCertificate(c) == nil {
+				return c, nil
+			}
+		}
+		return certs[0].get(), nil
+	}
+	return tlsConfig, nil
+}
+
+// reloadingCert is a certificate that is loaded again when its files
+// change on disk.
+type reloadingCert struct {
+	files Certificate
+
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	modTime   time.Time
+	checkedAt time.Time
+}
+
+// get returns the current certificate, reloading it first if the files
+// changed. If reloading fails, e.g. because only one of the files has
+// been replaced yet, the old certificate is kept.
+func (c *reloadingCert) get() *tls.Certificate {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if time.Since(c.checkedAt) < certCheckInterval {
+		return c.cert
+	}
+	c.checkedAt = time.Now()
+	modTime, err := c.filesModTime()
+	if err != nil || modTime.Equal(c.modTime) {
+		return c.cert
+	}
+	if err := c.loadLocked(); err != nil {
+		log.Printf("Error reloading certificate %s: %s", c.files.CertFile, err)
+	}
+	return c.cert
+}
+
+func (c *reloadingCert) load() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.loadLocked()
+}
+
+func (c *reloadingCert) loadLocked() error {
+	modTime, err := c.filesModTime()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(c.files.CertFile, c.files.KeyFile)
+	if err != nil {
+		return err
+	}
+	c.cert = &cert
+	c.modTime = modTime
+	c.checkedAt = time.Now()
+	return nil
+}
+
+// filesModTime returns the later modification time of the two files.
+func (c *reloadingCert) filesModTime() (time.Time, error) {
+	certInfo, err := os.Stat(c.files.CertFile)
+	if err != nil {
+		return time.Time{}, err
+	}
+	keyInfo, err := os.Stat(c.files.KeyFile)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if keyInfo.ModTime().After(certInfo.ModTime()) {
+		return keyInfo.ModTime(), nil
+	}
+	return certInfo.ModTime(), nil
+}
@@ -0,0 +1,141 @@
+package server
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/testcert"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// tlsInfo answers with what it knows about the TLS connection.
+func tlsInfo(w *response.Writer, req *request.Request) {
+	if req.TLS == nil {
+		fmt.Fprint(w, "plain")
+		return
+	}
+	fmt.Fprintf(w, "%s %s %d", tls.VersionName(req.TLS.Version), req.TLS.ServerName, len(req.TLS.PeerCertificates))
+}
+
+// getTLS sends a GET over a new TLS connection and returns the server's
+// certificate and the response body.
+func getTLS(t *testing.T, s *Server, config *tls.Config) (*x509.Certificate, string) {
+	t.Helper()
+	conn, err := tls.Dial("tcp", s.Addrs()[0].String(), config)
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = io.WriteString(conn, "GET / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	require.NoError(t, err)
+	res, err := io.ReadAll(bufio.NewReader(conn))
+	require.NoError(t, err)
+	_, body, _ := strings.Cut(string(res), "\r\n\r\n")
+	return conn.ConnectionState().PeerCertificates[0], body
+}
+
+func TestServeTLS(t *testing.T) {
+	ca, err := testcert.NewCA()
+	require.NoError(t, err)
+	certFile, keyFile, err := ca.LeafFiles(t.TempDir(), "localhost", "127.0.0.1")
+	require.NoError(t, err)
+
+	s, err := ServeTLS(0, certFile, keyFile, tlsInfo)
+	require.NoError(t, err)
+	defer s.Close()
+
+	// Test: Handlers see the connection state
+	cert, body := getTLS(t, s, &tls.Config{RootCAs: ca.Pool(), ServerName: "localhost"})
+	assert.Equal(t, []string{"localhost"}, cert.DNSNames)
+	assert.Equal(t, "TLS 1.3 localhost 0", body)
+
+	// Test: Missing files fail up front
+	_, err = ServeTLS(0, filepath.Join(t.TempDir(), "missing.pem"), keyFile, tlsInfo)
+	require.Error(t, err)
+}
+
+func TestServeTLSConfig(t *testing.T) {
+	ca, err := testcert.NewCA()
+	require.NoError(t, err)
+	aCert, aKey, err := ca.LeafFiles(t.TempDir(), "a.example")
+	require.NoError(t, err)
+	bCert, bKey, err := ca.LeafFiles(t.TempDir(), "b.example", "*.b.example")
+	require.NoError(t, err)
+	clientCert, err := ca.LeafCertificate("client")
+	require.NoError(t, err)
+
+	s, err := ServeTLSConfig(0, &TLSConfig{
+		Certificates: []Certificate{
+			{CertFile: aCert, KeyFile: aKey},
+			{CertFile: bCert, KeyFile: bKey},
+		},
+		Config: &tls.Config{
+			MinVersion: tls.VersionTLS12,
+			ClientAuth: tls.VerifyClientCertIfGiven,
+			ClientCAs:  ca.Pool(),
+		},
+	}, tlsInfo)
+	require.NoError(t, err)
+	defer s.Close()
+
+	// Test: The certificate is picked by SNI
+	cert, _ := getTLS(t, s, &tls.Config{RootCAs: ca.Pool(), ServerName: "www.b.example"})
+	assert.Equal(t, "b.example", cert.Subject.CommonName)
+	cert, _ = getTLS(t, s, &tls.Config{RootCAs: ca.Pool(), ServerName: "a.example"})
+	assert.Equal(t, "a.example", cert.Subject.CommonName)
+
+	// Test: Unknown names get the first certificate
+	cert, _ = getTLS(t, s, &tls.Config{InsecureSkipVerify: true, ServerName: "c.example"})
+	assert.Equal(t, "a.example", cert.Subject.CommonName)
+
+	// Test: Client certificates reach the handler
+	_, body := getTLS(t, s, &tls.Config{
+		RootCAs:      ca.Pool(),
+		ServerName:   "a.example",
+		Certificates: []tls.Certificate{clientCert},
+		MaxVersion:   tls.VersionTLS12,
+	})
+	assert.Equal(t, "TLS 1.2 a.example 1", body)
+}
+
+func TestServeTLSReload(t *testing.T) {
+	interval := certCheckInterval
+	certCheckInterval = 0
+	defer func() { certCheckInterval = interval }()
+
+	ca, err := testcert.NewCA()
+	require.NoError(t, err)
+	dir := t.TempDir()
+	certFile, keyFile, err := ca.LeafFiles(dir, "localhost")
+	require.NoError(t, err)
+
+	s, err := ServeTLS(0, certFile, keyFile, tlsInfo)
+	require.NoError(t, err)
+	defer s.Close()
+	config := &tls.Config{RootCAs: ca.Pool(), ServerName: "localhost"}
+	before, _ := getTLS(t, s, config)
+
+	// Test: A broken file keeps the old certificate
+	later := time.Now().Add(time.Minute)
+	require.NoError(t, os.WriteFile(keyFile, []byte("garbage"), 0o600))
+	require.NoError(t, os.Chtimes(keyFile, later, later))
+	cert, _ := getTLS(t, s, config)
+	assert.Equal(t, before.SerialNumber, cert.SerialNumber)
+
+	// Test: A new certificate is picked up
+	_, _, err = ca.LeafFiles(dir, "localhost")
+	require.NoError(t, err)
+	later = later.Add(time.Minute)
+	require.NoError(t, os.Chtimes(certFile, later, later))
+	cert, _ = getTLS(t, s, config)
+	assert.NotEqual(t, before.SerialNumber, cert.SerialNumber)
+}
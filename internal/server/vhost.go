@@ -0,0 +1,88 @@
+package server
+
+import (
+	"fmt"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"strings"
+)
+
+// VirtualHosts dispatches requests to a handler by the host they are for.
+// Its Serve method is a Handler.
+type VirtualHosts struct {
+	hosts map[string]Handler
+	// wildcards maps a suffix like ".example.com" to its handler
+	wildcards map[string]Handler
+	// Default handles requests for hosts nobody registered, e.g. with a
+	// custom 404 page. If it is nil they get a plain 404.
+	Default Handler
+}
+
+func NewVirtualHosts() *VirtualHosts {
+	return &VirtualHosts{
+		hosts:     map[string]Handler{},
+		wildcards: map[string]Handler{},
+	}
+}
+
+// Handle registers handler for host. A host like "*.example.com" matches
+// every subdomain of example.com at any depth, but not example.com itself.
+// Exact hosts win over wildcards and longer wildcards over shorter ones.
+// Ports are ignored.
+func (v *VirtualHosts) Handle(host string, handler Handler) {
+	host = normalizeHost(host)
+	if suffix, ok := strings.CutPrefix(host, "*"); ok {
+		if !strings.HasPrefix(suffix, ".") || len(suffix) < 2 {
+			panic(fmt.Sprintf("server: invalid wildcard host %q", host))
+		}
+		v.wildcards[suffix] = handler
+		return
+	}
+	v.hosts[host] = handler
+}
+
+func (v *VirtualHosts) Serve(w *response.Writer, req *request.Request) {
+	if handler := v.lookup(normalizeHost(req.Host())); handler != nil {
+		handler(w, req)
+		return
+	}
+	if v.Default != nil {
+		v.Default(w, req)
+		return
+	}
+	unknownHost(w, req)
+}
+
+func unknownHost(w *response.Writer, _ *request.Request) {
+	body := response.ErrorPage(response.StatusNotFound, "")
+	w.WriteStatusLine(response.StatusNotFound)
+	w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+	w.WriteBody(body)
+}
+
+func (v *VirtualHosts) lookup(host string) Handler {
+	if handler, ok := v.hosts[host]; ok {
+		return handler
+	}
+	// try the longest suffix first: a.b.example.com, then b.example.com...
+	for idx := strings.Index(host, "."); idx != -1; {
+		if handler, ok := v.wildcards[host[idx:]]; ok {
+			return handler
+		}
+		next := strings.Index(host[idx+1:], ".")
+		if next == -1 {
+			break
+		}
+		idx += next + 1
+	}
+	return nil
+}
+
+// normalizeHost lowercases and drops the port and a trailing dot.
+func normalizeHost(host string) string {
+	host = strings.ToLower(host)
+	if idx := strings.LastIndex(host, ":"); idx != -1 && !strings.HasSuffix(host, "]") {
+		host = host[:idx]
+	}
+	return strings.TrimSuffix(host, ".")
+}
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"bytes"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVirtualHosts(t *testing.T) {
+	site := func(name string) Handler {
+		return func(w *response.Writer, _ *request.Request) {
+			body := []byte(name)
+			w.WriteStatusLine(response.StatusOK)
+			w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+			w.WriteBody(body)
+		}
+	}
+	serve := func(v *VirtualHosts, host string) string {
+		req, err := request.RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: " + host + "\r\n\r\n"))
+		require.NoError(t, err)
+		buf := &bytes.Buffer{}
+		v.Serve(response.NewWriter(buf), req)
+		return buf.String()
+	}
+
+	v := NewVirtualHosts()
+	v.Handle("example.com", site("apex"))
+	v.Handle("*.example.com", site("any-subdomain"))
+	v.Handle("*.api.example.com", site("any-api"))
+	v.Handle("www.example.com", site("www"))
+
+	// Test: Exact hosts, ignoring case and port
+	assert.True(t, strings.HasSuffix(serve(v, "example.com"), "apex"))
+	assert.True(t, strings.HasSuffix(serve(v, "WWW.Example.com:8080"), "www"))
+	assert.True(t, strings.HasSuffix(serve(v, "example.com."), "apex"))
+
+	// Test: Longest wildcard wins
+	assert.True(t, strings.HasSuffix(serve(v, "blog.example.com"), "any-subdomain"))
+	assert.True(t, strings.HasSuffix(serve(v, "a.b.example.com"), "any-subdomain"))
+	assert.True(t, strings.HasSuffix(serve(v, "v1.api.example.com"), "any-api"))
+
+	// Test: Unknown host without and with a default
+	res := serve(v, "example.org")
+	assert.True(t, strings.HasPrefix(res, "HTTP/1.1 404 Not Found\r\n"))
+	assert.Contains(t, res, "<h1>Not Found</h1>")
+	assert.NotContains(t, res, "example.org")
+	v.Default = site("default")
+	assert.True(t, strings.HasSuffix(serve(v, "example.org"), "default"))
+	assert.True(t, strings.HasSuffix(serve(v, "notexample.com"), "default"))
+
+	// Test: Invalid wildcard
+	assert.Panics(t, func() { v.Handle("*example.com", site("bad")) })
+}